@@ -5,6 +5,7 @@ import (
 	"github.com/urfave/cli"
 	"os"
 	"toy-runc/internal/command"
+	"toy-runc/internal/container"
 )
 
 const Usage = "This is a simple container runtime implementation."
@@ -20,6 +21,9 @@ func main() {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
 		logrus.SetOutput(os.Stdout)
 
+		if container.Rootless {
+			return container.SetupRootless()
+		}
 		return nil
 	}
 
@@ -5,36 +5,109 @@ import (
 	"toy-runc/internal/cgroups/subsystems"
 )
 
+// cgroupBackend hides whether the resources end up on the v1 or the v2 hierarchy.
+type cgroupBackend interface {
+	Apply(pid int) error
+	Set(res *subsystems.ResourceConfig) error
+	Update(res *subsystems.ResourceConfig, controllers []string) error
+	Destroy() error
+	Freeze(state subsystems.FreezerState) error
+	GetStats() (*Stats, error)
+	GetPids() ([]int, error)
+	Delegate(uid, gid int) error
+	NotifyOOM() (<-chan uint64, error)
+	GetPressure() (*PressureStats, error)
+	NotifyPressure(trigger *PressureTrigger) (<-chan struct{}, error)
+	OpenPerfCounters(events []string) (*PerfCounters, error)
+}
+
 type CgroupManager struct {
 	Path     string
 	Resource *subsystems.ResourceConfig
+	Mode     CgroupMode
+	backend  cgroupBackend
 }
 
+// NewCgroupManager detects the cgroup layout of the host and picks the
+// matching backend, hybrid hosts keep their controllers on v1.
 func NewCgroupManager(path string) *CgroupManager {
+	mode, unifiedMountPoint := GetCgroupMode()
+	logrus.Infof("cgroup mode %s", mode)
+
+	var backend cgroupBackend
+	if mode == Unified {
+		backend = newUnifiedManager(unifiedMountPoint, path)
+	} else {
+		backend = newLegacyManager(path)
+	}
 	return &CgroupManager{
-		Path: path,
+		Path:    path,
+		Mode:    mode,
+		backend: backend,
 	}
 }
 
 func (c *CgroupManager) Apply(pid int) error {
-	for _, subSysIns := range subsystems.SubsystemIns {
-		subSysIns.Apply(c.Path, pid)
-	}
-	return nil
+	return c.backend.Apply(pid)
 }
 
 func (c *CgroupManager) Set(res *subsystems.ResourceConfig) error {
-	for _, subSysIns := range subsystems.SubsystemIns {
-		subSysIns.Set(c.Path, res)
-	}
-	return nil
+	c.Resource = res
+	return c.backend.Set(res)
+}
+
+// Update writes res to the named controllers only, so changing one limit of a
+// running container leaves the other controllers and the devices untouched.
+func (c *CgroupManager) Update(res *subsystems.ResourceConfig, controllers []string) error {
+	c.Resource = res
+	return c.backend.Update(res, controllers)
 }
 
 func (c *CgroupManager) Destroy() error {
-	for _, subSysIns := range subsystems.SubsystemIns {
-		if err := subSysIns.Remove(c.Path); err != nil {
-			logrus.Errorf("remove cgroup fail; err %v", err)
-		}
-	}
-	return nil
+	return c.backend.Destroy()
+}
+
+// Freeze stops (Frozen) or resumes (Thawed) every task in the cgroup.
+func (c *CgroupManager) Freeze(state subsystems.FreezerState) error {
+	return c.backend.Freeze(state)
+}
+
+// GetStats reads the cpu, memory, pids and block io counters of the cgroup.
+func (c *CgroupManager) GetStats() (*Stats, error) {
+	return c.backend.GetStats()
+}
+
+// GetPids lists the processes in the cgroup, by their pid on the host.
+func (c *CgroupManager) GetPids() ([]int, error) {
+	return c.backend.GetPids()
+}
+
+// Delegate hands the cgroup to uid and gid so a container can manage the
+// subtree below it, it is only supported on cgroup v2.
+func (c *CgroupManager) Delegate(uid, gid int) error {
+	return c.backend.Delegate(uid, gid)
+}
+
+// NotifyOOM returns a channel receiving the total number of oom kills each
+// time the kernel kills a task of the cgroup, it is closed once the cgroup is gone.
+func (c *CgroupManager) NotifyOOM() (<-chan uint64, error) {
+	return c.backend.NotifyOOM()
+}
+
+// GetPressure reads the cpu, memory and io pressure stall information of
+// the cgroup, it is only available on cgroup v2.
+func (c *CgroupManager) GetPressure() (*PressureStats, error) {
+	return c.backend.GetPressure()
+}
+
+// NotifyPressure returns a channel receiving an event each time the stall
+// of the trigger exceeds its threshold, it is closed once the cgroup is gone.
+func (c *CgroupManager) NotifyPressure(trigger *PressureTrigger) (<-chan struct{}, error) {
+	return c.backend.NotifyPressure(trigger)
+}
+
+// OpenPerfCounters opens disabled perf counters scoped to the cgroup for
+// the named events, the caller enables, reads and closes them.
+func (c *CgroupManager) OpenPerfCounters(events []string) (*PerfCounters, error) {
+	return c.backend.OpenPerfCounters(events)
 }
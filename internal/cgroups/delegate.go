@@ -0,0 +1,112 @@
+package cgroups
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+var errDelegateUnsupported = errors.New("cgroup delegation needs the controllers on cgroup v2")
+
+// defaultDelegateFiles are the files a delegatee owns on kernels without
+// /sys/kernel/cgroup/delegate, it appeared in linux 4.15.
+var defaultDelegateFiles = []string{"cgroup.procs", "cgroup.threads", "cgroup.subtree_control"}
+
+// delegateFiles lists the interface files of a cgroup the kernel considers
+// safe to hand to the owner of a delegated subtree.
+func delegateFiles() []string {
+	content, err := ioutil.ReadFile("/sys/kernel/cgroup/delegate")
+	if err != nil {
+		return defaultDelegateFiles
+	}
+	return strings.Fields(string(content))
+}
+
+// HasNsdelegate reports whether the cgroup2 hierarchy is mounted with
+// nsdelegate, which makes cgroup namespaces delegation boundaries: a
+// process can not move tasks across the root of its namespace nor write
+// the interface files of that root.
+func HasNsdelegate() bool {
+	mounts, err := GetCgroupMounts()
+	if err != nil {
+		return false
+	}
+	for _, mount := range mounts {
+		if mount.Fstype != "cgroup2" {
+			continue
+		}
+		for _, option := range strings.Split(mount.Options, ",") {
+			if option == "nsdelegate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EnableControllers writes every controller listed in cgroup.controllers to
+// cgroup.subtree_control, one at a time so a single refusal does not block
+// the rest, and returns the first refusal.
+func EnableControllers(dir string) error {
+	content, err := ioutil.ReadFile(path.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("read %s controllers error; %v", dir, err)
+	}
+	var firstErr error
+	for _, controller := range strings.Fields(string(content)) {
+		if err := writeFile(dir, "cgroup.subtree_control", "+"+controller); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("enable controller %s in %s fail; %v", controller, dir, err)
+		}
+	}
+	return firstErr
+}
+
+func (l *legacyManager) Delegate(uid, gid int) error {
+	return errDelegateUnsupported
+}
+
+// Delegate hands the cgroup directory and its delegatable files to uid and
+// gid, the owner may then create child cgroups, move its processes between
+// them and enable controllers for them, but not change the limits of the
+// cgroup itself.
+func (u *unifiedManager) Delegate(uid, gid int) error {
+	if err := os.Chown(u.path, uid, gid); err != nil {
+		return fmt.Errorf("chown cgroup %s fail; %v", u.path, err)
+	}
+	for _, file := range delegateFiles() {
+		if err := os.Chown(path.Join(u.path, file), uid, gid); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("chown cgroup %s fail; %v", path.Join(u.path, file), err)
+		}
+	}
+	return nil
+}
+
+// RootlessCgroupParent finds a cgroup an unprivileged user may create its
+// container cgroups in: name next to the cgroup of the caller, provided the
+// parent of that cgroup is delegated to the user on cgroup v2, as systemd
+// does for user@<uid>.service. The result is relative to the hierarchy root.
+func RootlessCgroupParent(name string) (string, error) {
+	mode, root := GetCgroupMode()
+	if mode != Unified {
+		return "", fmt.Errorf("the %s cgroup hierarchy of this host can not be delegated", mode)
+	}
+	content, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if !strings.HasPrefix(line, "0::") {
+			continue
+		}
+		parent := path.Dir(strings.TrimPrefix(line, "0::"))
+		if err := unix.Access(path.Join(root, parent), unix.W_OK); err != nil {
+			return "", fmt.Errorf("cgroup %s is not delegated to the user", parent)
+		}
+		return path.Join(parent, name), nil
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in /proc/self/cgroup")
+}
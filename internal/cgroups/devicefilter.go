@@ -0,0 +1,270 @@
+package cgroups
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"runtime"
+	"strings"
+	"toy-runc/internal/cgroups/subsystems"
+	"unsafe"
+)
+
+// cgroup v2 has no devices.allow, access to device nodes is decided by a
+// BPF_PROG_TYPE_CGROUP_DEVICE program attached to the cgroup. The program
+// receives a struct bpf_cgroup_dev_ctx { u32 access_type; u32 major; u32 minor; }
+// where access_type is (access << 16) | type, it returns 1 to allow and 0 to deny.
+
+const (
+	bpfLicense = "MIT"
+	bpfLogSize = 64 * 1024
+
+	bpfAccessAll = unix.BPF_DEVCG_ACC_MKNOD | unix.BPF_DEVCG_ACC_READ | unix.BPF_DEVCG_ACC_WRITE
+)
+
+// bpfInsn is the kernel's struct bpf_insn, regs holds dst_reg in the low
+// nibble and src_reg in the high nibble.
+type bpfInsn struct {
+	code uint8
+	regs uint8
+	off  int16
+	imm  int32
+}
+
+func ldxw(dst, src uint8, off int16) bpfInsn {
+	return bpfInsn{code: unix.BPF_LDX | unix.BPF_MEM | unix.BPF_W, regs: src<<4 | dst, off: off}
+}
+
+func aluImm(op uint8, dst uint8, imm int32) bpfInsn {
+	return bpfInsn{code: unix.BPF_ALU64 | op | unix.BPF_K, regs: dst, imm: imm}
+}
+
+func movReg(dst, src uint8) bpfInsn {
+	return bpfInsn{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X, regs: src<<4 | dst}
+}
+
+func jneImm(dst uint8, imm int32) bpfInsn {
+	return bpfInsn{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, regs: dst, imm: imm}
+}
+
+func jneReg(dst, src uint8) bpfInsn {
+	return bpfInsn{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_X, regs: src<<4 | dst}
+}
+
+func exit() bpfInsn {
+	return bpfInsn{code: unix.BPF_JMP | unix.BPF_EXIT}
+}
+
+// deviceFilterProgram compiles the allowlist, the first matching rule allows
+// the access and anything left over is denied.
+func deviceFilterProgram(rules []subsystems.DeviceRule) ([]bpfInsn, error) {
+	prog := []bpfInsn{
+		// r2 = type, r3 = access, r4 = major, r5 = minor
+		ldxw(2, 1, 0),
+		aluImm(unix.BPF_AND, 2, 0xffff),
+		ldxw(3, 1, 0),
+		aluImm(unix.BPF_RSH, 3, 16),
+		ldxw(4, 1, 4),
+		ldxw(5, 1, 8),
+	}
+	for _, rule := range rules {
+		block, err := deviceRuleBlock(rule)
+		if err != nil {
+			return nil, err
+		}
+		prog = append(prog, block...)
+	}
+	return append(prog, aluImm(unix.BPF_MOV, 0, 0), exit()), nil
+}
+
+// deviceRuleBlock returns "if every field matches return 1", each mismatch
+// jumps past the end of the block onto the next rule.
+func deviceRuleBlock(rule subsystems.DeviceRule) ([]bpfInsn, error) {
+	var block []bpfInsn
+	switch rule.Type {
+	case subsystems.AllDevices:
+	case subsystems.CharDevice:
+		block = append(block, jneImm(2, unix.BPF_DEVCG_DEV_CHAR))
+	case subsystems.BlockDevice:
+		block = append(block, jneImm(2, unix.BPF_DEVCG_DEV_BLOCK))
+	default:
+		return nil, fmt.Errorf("invalid device type %s", rule.Type)
+	}
+
+	access := int32(0)
+	for _, perm := range rule.Permissions {
+		switch perm {
+		case 'm':
+			access |= unix.BPF_DEVCG_ACC_MKNOD
+		case 'r':
+			access |= unix.BPF_DEVCG_ACC_READ
+		case 'w':
+			access |= unix.BPF_DEVCG_ACC_WRITE
+		default:
+			return nil, fmt.Errorf("invalid device permissions %s", rule.Permissions)
+		}
+	}
+	if access != bpfAccessAll {
+		// the requested access must be a subset of the allowed one.
+		block = append(block,
+			movReg(1, 3),
+			aluImm(unix.BPF_AND, 1, access),
+			jneReg(1, 3),
+		)
+	}
+	if rule.Major != subsystems.Wildcard {
+		block = append(block, jneImm(4, int32(rule.Major)))
+	}
+	if rule.Minor != subsystems.Wildcard {
+		block = append(block, jneImm(5, int32(rule.Minor)))
+	}
+	block = append(block, aluImm(unix.BPF_MOV, 0, 1), exit())
+
+	for i := range block {
+		if block[i].code&0x07 == unix.BPF_JMP && block[i].code&0xf0 == unix.BPF_JNE {
+			block[i].off = int16(len(block) - i - 1)
+		}
+	}
+	return block, nil
+}
+
+type bpfProgLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+}
+
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+type bpfProgQueryAttr struct {
+	targetFd    uint32
+	attachType  uint32
+	queryFlags  uint32
+	attachFlags uint32
+	progIds     uint64
+	progCnt     uint32
+	_           uint32
+}
+
+type bpfProgGetFdAttr struct {
+	progId    uint32
+	nextId    uint32
+	openFlags uint32
+}
+
+// setDeviceFilter loads the allowlist program and attaches it to the cgroup
+// directory. Like runc it attaches with BPF_F_ALLOW_MULTI, so a delegated
+// cgroup below can attach its own filter, and detaches the programs of a
+// previous Set once the new one is in place.
+func setDeviceFilter(dir string, rules []subsystems.DeviceRule) error {
+	insns, err := deviceFilterProgram(rules)
+	if err != nil {
+		return err
+	}
+
+	license := []byte(bpfLicense + "\x00")
+	logBuf := make([]byte, bpfLogSize)
+	loadAttr := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  bpfLogSize,
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	progFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&loadAttr)), unsafe.Sizeof(loadAttr))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		verifierLog := strings.TrimRight(string(logBuf), "\x00")
+		return fmt.Errorf("load device filter program fail; %v: %s", errno, verifierLog)
+	}
+	defer unix.Close(int(progFd))
+
+	dirFd, err := unix.Open(dir, unix.O_DIRECTORY|unix.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open cgroup %s error; %v", dir, err)
+	}
+	defer unix.Close(dirFd)
+
+	oldIds, err := queryDeviceFilters(dirFd)
+	if err != nil {
+		return fmt.Errorf("query device filter programs of %s fail; %v", dir, err)
+	}
+
+	attachAttr := bpfProgAttachAttr{
+		targetFd:    uint32(dirFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+		attachFlags: unix.BPF_F_ALLOW_MULTI,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attachAttr)), unsafe.Sizeof(attachAttr)); errno != 0 {
+		return fmt.Errorf("attach device filter program to %s fail; %v", dir, errno)
+	}
+
+	// until the old programs are gone every attached program has to allow
+	// an access, the container never gets more than either rule set.
+	for _, id := range oldIds {
+		if err := detachDeviceFilter(dirFd, id); err != nil {
+			return fmt.Errorf("detach device filter program %d from %s fail; %v", id, dir, err)
+		}
+	}
+	return nil
+}
+
+// queryDeviceFilters returns the ids of the device programs attached to the
+// cgroup itself, not those it inherits.
+func queryDeviceFilters(dirFd int) ([]uint32, error) {
+	for size := 4; ; size *= 2 {
+		ids := make([]uint32, size)
+		queryAttr := bpfProgQueryAttr{
+			targetFd:   uint32(dirFd),
+			attachType: unix.BPF_CGROUP_DEVICE,
+			progIds:    uint64(uintptr(unsafe.Pointer(&ids[0]))),
+			progCnt:    uint32(len(ids)),
+		}
+		_, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_QUERY, uintptr(unsafe.Pointer(&queryAttr)), unsafe.Sizeof(queryAttr))
+		runtime.KeepAlive(ids)
+		// ENOSPC reports in progCnt how many programs are attached.
+		if errno == unix.ENOSPC {
+			continue
+		}
+		if errno != 0 {
+			return nil, errno
+		}
+		return ids[:queryAttr.progCnt], nil
+	}
+}
+
+func detachDeviceFilter(dirFd int, id uint32) error {
+	getFdAttr := bpfProgGetFdAttr{progId: id}
+	progFd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_GET_FD_BY_ID, uintptr(unsafe.Pointer(&getFdAttr)), unsafe.Sizeof(getFdAttr))
+	if errno != 0 {
+		return errno
+	}
+	defer unix.Close(int(progFd))
+
+	detachAttr := bpfProgAttachAttr{
+		targetFd:    uint32(dirFd),
+		attachBpfFd: uint32(progFd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_DETACH, uintptr(unsafe.Pointer(&detachAttr)), unsafe.Sizeof(detachAttr)); errno != 0 {
+		return errno
+	}
+	return nil
+}
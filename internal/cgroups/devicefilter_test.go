@@ -0,0 +1,159 @@
+package cgroups
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"reflect"
+	"testing"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+// runDeviceFilter interprets the instructions deviceFilterProgram emits on
+// a struct bpf_cgroup_dev_ctx, jumps out of the program are an error.
+func runDeviceFilter(prog []bpfInsn, devType, access, major, minor uint32) (uint64, error) {
+	ctx := []uint32{access<<16 | devType, major, minor}
+	var regs [11]uint64
+	for pc := 0; pc < len(prog); pc++ {
+		insn := prog[pc]
+		dst, src := insn.regs&0x0f, insn.regs>>4
+		switch insn.code {
+		case unix.BPF_LDX | unix.BPF_MEM | unix.BPF_W:
+			if src != 1 || insn.off < 0 || insn.off%4 != 0 || int(insn.off/4) >= len(ctx) {
+				return 0, fmt.Errorf("load of r%d offset %d at %d", src, insn.off, pc)
+			}
+			regs[dst] = uint64(ctx[insn.off/4])
+		case unix.BPF_ALU64 | unix.BPF_AND | unix.BPF_K:
+			regs[dst] &= uint64(int64(insn.imm))
+		case unix.BPF_ALU64 | unix.BPF_RSH | unix.BPF_K:
+			regs[dst] >>= uint(insn.imm)
+		case unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K:
+			regs[dst] = uint64(int64(insn.imm))
+		case unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X:
+			regs[dst] = regs[src]
+		case unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K:
+			if regs[dst] != uint64(int64(insn.imm)) {
+				pc += int(insn.off)
+			}
+		case unix.BPF_JMP | unix.BPF_JNE | unix.BPF_X:
+			if regs[dst] != regs[src] {
+				pc += int(insn.off)
+			}
+		case unix.BPF_JMP | unix.BPF_EXIT:
+			return regs[0], nil
+		default:
+			return 0, fmt.Errorf("unknown instruction %#x at %d", insn.code, pc)
+		}
+		if pc+1 >= len(prog) {
+			return 0, fmt.Errorf("instruction %d jumps out of the program", pc)
+		}
+	}
+	return 0, fmt.Errorf("program does not exit")
+}
+
+func TestDeviceRuleBlock(t *testing.T) {
+	tests := []struct {
+		rule    subsystems.DeviceRule
+		want    []bpfInsn
+		wantErr bool
+	}{
+		{
+			subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 1, Minor: 3, Permissions: "rwm"},
+			[]bpfInsn{
+				{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, regs: 2, off: 4, imm: unix.BPF_DEVCG_DEV_CHAR},
+				{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, regs: 4, off: 3, imm: 1},
+				{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, regs: 5, off: 2, imm: 3},
+				{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K, regs: 0, imm: 1},
+				{code: unix.BPF_JMP | unix.BPF_EXIT},
+			},
+			false,
+		},
+		{
+			subsystems.DeviceRule{Type: subsystems.BlockDevice, Major: subsystems.Wildcard, Minor: subsystems.Wildcard, Permissions: "m"},
+			[]bpfInsn{
+				{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, regs: 2, off: 5, imm: unix.BPF_DEVCG_DEV_BLOCK},
+				{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X, regs: 3<<4 | 1},
+				{code: unix.BPF_ALU64 | unix.BPF_AND | unix.BPF_K, regs: 1, imm: unix.BPF_DEVCG_ACC_MKNOD},
+				{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_X, regs: 3<<4 | 1, off: 2},
+				{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K, regs: 0, imm: 1},
+				{code: unix.BPF_JMP | unix.BPF_EXIT},
+			},
+			false,
+		},
+		{
+			subsystems.DeviceRule{Type: subsystems.AllDevices, Major: subsystems.Wildcard, Minor: 7, Permissions: "rwm"},
+			[]bpfInsn{
+				{code: unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K, regs: 5, off: 2, imm: 7},
+				{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K, regs: 0, imm: 1},
+				{code: unix.BPF_JMP | unix.BPF_EXIT},
+			},
+			false,
+		},
+		{subsystems.DeviceRule{Type: "x", Major: 1, Minor: 3, Permissions: "rwm"}, nil, true},
+		{subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 1, Minor: 3, Permissions: "rx"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := deviceRuleBlock(tt.rule)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("deviceRuleBlock(%s) error = %v, wantErr %v", tt.rule, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("deviceRuleBlock(%s) = %+v, want %+v", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestDeviceFilterProgram(t *testing.T) {
+	const (
+		char  = unix.BPF_DEVCG_DEV_CHAR
+		block = unix.BPF_DEVCG_DEV_BLOCK
+		r     = unix.BPF_DEVCG_ACC_READ
+		w     = unix.BPF_DEVCG_ACC_WRITE
+		m     = unix.BPF_DEVCG_ACC_MKNOD
+	)
+	rules := append([]subsystems.DeviceRule{}, subsystems.DefaultAllowedDevices...)
+	rules = append(rules,
+		subsystems.DeviceRule{Type: subsystems.BlockDevice, Major: 8, Minor: 0, Permissions: "r"},
+		subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 4, Minor: subsystems.Wildcard, Permissions: "rw"},
+	)
+	tests := []struct {
+		name            string
+		rules           []subsystems.DeviceRule
+		devType, access uint32
+		major, minor    uint32
+		want            uint64
+	}{
+		{"no rules", nil, char, r, 1, 3, 0},
+		{"null", rules, char, r | w, 1, 3, 1},
+		{"null mknod", rules, char, m, 1, 3, 1},
+		{"urandom", rules, char, r, 1, 9, 1},
+		{"pts wildcard minor", rules, char, r | w, 136, 42, 1},
+		{"mknod any char", rules, char, m, 10, 200, 1},
+		{"mknod any block", rules, block, m, 259, 1, 1},
+		{"read other char", rules, char, r, 10, 200, 0},
+		{"block read", rules, block, r, 8, 0, 1},
+		{"block write", rules, block, w, 8, 0, 0},
+		{"block read and write", rules, block, r | w, 8, 0, 0},
+		{"block other minor", rules, block, r, 8, 1, 0},
+		{"char of block numbers", rules, char, r, 8, 0, 0},
+		{"tty wildcard minor", rules, char, r | w, 4, 5, 1},
+		{"tty mknod", rules, char, m, 4, 5, 1},
+		{"tty wildcard type", rules, block, r, 4, 5, 0},
+		{"all devices", []subsystems.DeviceRule{{Type: subsystems.AllDevices, Major: subsystems.Wildcard, Minor: subsystems.Wildcard, Permissions: "rwm"}}, block, r | w | m, 8, 1, 1},
+	}
+	for _, tt := range tests {
+		prog, err := deviceFilterProgram(tt.rules)
+		if err != nil {
+			t.Errorf("%s: deviceFilterProgram error %v", tt.name, err)
+			continue
+		}
+		got, err := runDeviceFilter(prog, tt.devType, tt.access, tt.major, tt.minor)
+		if err != nil {
+			t.Errorf("%s: run program error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: program returns %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
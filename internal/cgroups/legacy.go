@@ -0,0 +1,140 @@
+package cgroups
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+// legacyManager drives one directory per controller on the cgroup v1 hierarchies.
+type legacyManager struct {
+	path string
+}
+
+func newLegacyManager(path string) *legacyManager {
+	return &legacyManager{
+		path: path,
+	}
+}
+
+// mountedSubsystems filters out the controllers that have no v1 hierarchy
+// on this host, writing to them would only create stray directories.
+func mountedSubsystems() []subsystems.Subsystem {
+	var mounted []subsystems.Subsystem
+	for _, subSysIns := range subsystems.SubsystemIns {
+		if subsystems.FindCgroupMountPoint(subSysIns.Name()) == "" {
+			logrus.Warnf("cgroup subsystem %s is not mounted, skip it", subSysIns.Name())
+			continue
+		}
+		mounted = append(mounted, subSysIns)
+	}
+	return mounted
+}
+
+func (l *legacyManager) Apply(pid int) error {
+	var firstErr error
+	for _, subSysIns := range mountedSubsystems() {
+		if err := subSysIns.Apply(l.path, pid); err != nil {
+			logrus.Errorf("apply cgroup subsystem %s fail; %v", subSysIns.Name(), err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("apply cgroup subsystem %s fail; %v", subSysIns.Name(), err)
+			}
+		}
+	}
+	return firstErr
+}
+
+func (l *legacyManager) Set(res *subsystems.ResourceConfig) error {
+	var firstErr error
+	for _, subSysIns := range mountedSubsystems() {
+		if err := subSysIns.Set(l.path, res); err != nil {
+			logrus.Errorf("set cgroup subsystem %s fail; %v", subSysIns.Name(), err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("set cgroup subsystem %s fail; %v", subSysIns.Name(), err)
+			}
+		}
+	}
+	return firstErr
+}
+
+func (l *legacyManager) Update(res *subsystems.ResourceConfig, controllers []string) error {
+	var firstErr error
+	for _, subSysIns := range mountedSubsystems() {
+		if !containsString(controllers, subSysIns.Name()) {
+			continue
+		}
+		if err := subSysIns.Set(l.path, res); err != nil {
+			logrus.Errorf("update cgroup subsystem %s fail; %v", subSysIns.Name(), err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("update cgroup subsystem %s fail; %v", subSysIns.Name(), err)
+			}
+		}
+	}
+	return firstErr
+}
+
+func (l *legacyManager) Destroy() error {
+	// co-mounted controllers such as cpu,cpuacct share one directory.
+	removed := map[string]bool{}
+	for _, subSysIns := range mountedSubsystems() {
+		mountPoint := subsystems.FindCgroupMountPoint(subSysIns.Name())
+		if removed[mountPoint] {
+			continue
+		}
+		removed[mountPoint] = true
+		if err := subSysIns.Remove(l.path); err != nil {
+			logrus.Errorf("remove cgroup fail; err %v", err)
+		}
+	}
+	return nil
+}
+
+func (l *legacyManager) Freeze(state subsystems.FreezerState) error {
+	freezer := &subsystems.FreezerSubsystem{}
+	if subsystems.FindCgroupMountPoint(freezer.Name()) == "" {
+		return fmt.Errorf("cgroup subsystem %s is not mounted", freezer.Name())
+	}
+	return freezer.Freeze(l.path, state)
+}
+
+func (l *legacyManager) GetStats() (*Stats, error) {
+	stats := &Stats{}
+	cpuacct := &subsystems.CpuacctSubsystem{}
+	if cpuStats, err := cpuacct.GetStats(l.path); err == nil {
+		stats.Cpu = *cpuStats
+	} else {
+		logrus.Warnf("get cgroup %s cpuacct stats fail; %v", l.path, err)
+	}
+	memory := &subsystems.MemorySubsystem{}
+	if memoryStats, err := memory.GetStats(l.path); err == nil {
+		stats.Memory = *memoryStats
+	} else {
+		logrus.Warnf("get cgroup %s memory stats fail; %v", l.path, err)
+	}
+	pids := &subsystems.PidsSubsystem{}
+	if pidsStats, err := pids.GetStats(l.path); err == nil {
+		stats.Pids = *pidsStats
+	} else {
+		logrus.Warnf("get cgroup %s pids stats fail; %v", l.path, err)
+	}
+	blkio := &subsystems.BlkioSubsystem{}
+	if blkioStats, err := blkio.GetStats(l.path); err == nil {
+		stats.Blkio = *blkioStats
+	} else {
+		logrus.Warnf("get cgroup %s blkio stats fail; %v", l.path, err)
+	}
+	return stats, nil
+}
+
+// GetPids reads cgroup.procs of the first mounted controller, every
+// controller holds the same processes.
+func (l *legacyManager) GetPids() ([]int, error) {
+	for _, subSysIns := range mountedSubsystems() {
+		dir, err := subsystems.GetCgroupPath(subSysIns.Name(), l.path, false)
+		if err != nil {
+			return nil, err
+		}
+		return subsystems.ReadProcs(dir)
+	}
+	return nil, fmt.Errorf("no cgroup subsystem is mounted")
+}
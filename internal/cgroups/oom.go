@@ -0,0 +1,135 @@
+package cgroups
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+const (
+	oomKillRetries       = 10
+	oomKillRetryInterval = 10 * time.Millisecond
+)
+
+// NotifyOOM on cgroup v1 registers an eventfd for memory.oom_control through
+// cgroup.event_control, the kernel signals it on every oom in the cgroup and
+// once more when the cgroup is removed.
+func (l *legacyManager) NotifyOOM() (<-chan uint64, error) {
+	memory := &subsystems.MemorySubsystem{}
+	dir, err := subsystems.GetCgroupPath(memory.Name(), l.path, false)
+	if err != nil {
+		return nil, err
+	}
+	oomControl, err := os.Open(path.Join(dir, "memory.oom_control"))
+	if err != nil {
+		return nil, fmt.Errorf("open memory.oom_control fail; %v", err)
+	}
+	efd, err := unix.Eventfd(0, unix.EFD_CLOEXEC)
+	if err != nil {
+		oomControl.Close()
+		return nil, fmt.Errorf("create eventfd fail; %v", err)
+	}
+	eventControl := fmt.Sprintf("%d %d", efd, oomControl.Fd())
+	if err := ioutil.WriteFile(path.Join(dir, "cgroup.event_control"), []byte(eventControl), 0644); err != nil {
+		unix.Close(efd)
+		oomControl.Close()
+		return nil, fmt.Errorf("register oom event fail; %v", err)
+	}
+
+	initial, err := subsystems.ReadKeyValues(path.Join(dir, "memory.oom_control"))
+	if err != nil {
+		unix.Close(efd)
+		oomControl.Close()
+		return nil, fmt.Errorf("read memory.oom_control fail; %v", err)
+	}
+
+	events := make(chan uint64)
+	go func() {
+		defer close(events)
+		defer unix.Close(efd)
+		defer oomControl.Close()
+		// oom_kill only exists since linux 4.13, older kernels only tell
+		// that an oom happened.
+		last, hasKillCounter := initial["oom_kill"]
+		buf := make([]byte, 8)
+		for {
+			if _, err := unix.Read(efd, buf); err != nil {
+				return
+			}
+			if _, err := os.Stat(path.Join(dir, "cgroup.event_control")); os.IsNotExist(err) {
+				return
+			}
+			values, err := subsystems.ReadKeyValues(path.Join(dir, "memory.oom_control"))
+			if err != nil {
+				return
+			}
+			// with the oom killer disabled the tasks only wait for memory.
+			if values["oom_kill_disable"] == 1 {
+				continue
+			}
+			if !hasKillCounter {
+				last++
+				events <- last
+				continue
+			}
+			// the event fires before the victim is killed, give the kernel
+			// a moment to count it. A later event catches up on a kill that
+			// takes longer.
+			for i := 0; i < oomKillRetries && values["oom_kill"] <= last; i++ {
+				time.Sleep(oomKillRetryInterval)
+				if values, err = subsystems.ReadKeyValues(path.Join(dir, "memory.oom_control")); err != nil {
+					return
+				}
+			}
+			if kills := values["oom_kill"]; kills > last {
+				last = kills
+				events <- kills
+			}
+		}
+	}()
+	return events, nil
+}
+
+// NotifyOOM on cgroup v2 watches memory.events with inotify, the kernel
+// touches it whenever one of its counters changes.
+func (u *unifiedManager) NotifyOOM() (<-chan uint64, error) {
+	eventsFile := path.Join(u.path, "memory.events")
+	values, err := subsystems.ReadKeyValues(eventsFile)
+	if err != nil {
+		return nil, fmt.Errorf("read memory.events fail; %v", err)
+	}
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return nil, fmt.Errorf("inotify init fail; %v", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, eventsFile, unix.IN_MODIFY); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("inotify watch %s fail; %v", eventsFile, err)
+	}
+
+	events := make(chan uint64)
+	go func() {
+		defer close(events)
+		defer unix.Close(fd)
+		last := values["oom_kill"]
+		buf := make([]byte, unix.SizeofInotifyEvent+unix.PathMax+1)
+		for {
+			if _, err := unix.Read(fd, buf); err != nil {
+				return
+			}
+			values, err := subsystems.ReadKeyValues(eventsFile)
+			if err != nil {
+				return
+			}
+			if kills := values["oom_kill"]; kills > last {
+				last = kills
+				events <- kills
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,171 @@
+package cgroups
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"sort"
+	"strings"
+	"toy-runc/internal/cgroups/subsystems"
+	"unsafe"
+)
+
+// DefaultPerfEvents are counted when no event is asked for, all of them
+// are software events and work on VMs without a PMU.
+var DefaultPerfEvents = []string{"task-clock", "context-switches", "page-faults"}
+
+type perfEvent struct {
+	typ    uint32
+	config uint64
+}
+
+// perfEvents maps the perf tool names onto the generic kernel events.
+var perfEvents = map[string]perfEvent{
+	"task-clock":       {unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_TASK_CLOCK},
+	"cpu-clock":        {unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_CPU_CLOCK},
+	"context-switches": {unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_CONTEXT_SWITCHES},
+	"cpu-migrations":   {unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_CPU_MIGRATIONS},
+	"page-faults":      {unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_PAGE_FAULTS},
+	"minor-faults":     {unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_PAGE_FAULTS_MIN},
+	"major-faults":     {unix.PERF_TYPE_SOFTWARE, unix.PERF_COUNT_SW_PAGE_FAULTS_MAJ},
+	"cycles":           {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CPU_CYCLES},
+	"instructions":     {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_INSTRUCTIONS},
+	"cache-references": {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CACHE_REFERENCES},
+	"cache-misses":     {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CACHE_MISSES},
+	"branches":         {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_BRANCH_INSTRUCTIONS},
+	"branch-misses":    {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_BRANCH_MISSES},
+}
+
+// PerfEventNames lists the supported event names.
+func PerfEventNames() []string {
+	var names []string
+	for name := range perfEvents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PerfCount is the total of one event over every cpu. The kernel
+// multiplexes hardware counters when there are more events than counters,
+// Enabled and Running are the nanoseconds the event was enabled and
+// actually counted, Value is already scaled up to the enabled time.
+type PerfCount struct {
+	Event   string `json:"event"`
+	Value   uint64 `json:"value"`
+	Enabled uint64 `json:"enabled"`
+	Running uint64 `json:"running"`
+}
+
+// PerfCounters are cgroup scoped counters, one per event and online cpu:
+// a cgroup counter only counts the tasks of the cgroup running on its cpu.
+type PerfCounters struct {
+	events []string
+	fds    map[string][]int
+}
+
+// openPerfCounters opens the counters on the cgroup directory dir of the
+// perf_event controller, they start counting on Enable.
+func openPerfCounters(dir string, events []string) (*PerfCounters, error) {
+	cpus, err := subsystems.OnlineCpus()
+	if err != nil {
+		return nil, fmt.Errorf("read online cpus error; %v", err)
+	}
+	cgroupFile, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("open cgroup %s error; %v", dir, err)
+	}
+	defer cgroupFile.Close()
+
+	counters := &PerfCounters{
+		events: events,
+		fds:    map[string][]int{},
+	}
+	for _, name := range events {
+		event, ok := perfEvents[name]
+		if !ok {
+			counters.Close()
+			return nil, fmt.Errorf("unknown perf event %s, supported events %s", name, strings.Join(PerfEventNames(), ","))
+		}
+		attr := &unix.PerfEventAttr{
+			Type:        event.typ,
+			Size:        uint32(unsafe.Sizeof(unix.PerfEventAttr{})),
+			Config:      event.config,
+			Read_format: unix.PERF_FORMAT_TOTAL_TIME_ENABLED | unix.PERF_FORMAT_TOTAL_TIME_RUNNING,
+			Bits:        unix.PerfBitDisabled,
+		}
+		for _, cpu := range cpus {
+			fd, err := unix.PerfEventOpen(attr, int(cgroupFile.Fd()), cpu, -1, unix.PERF_FLAG_PID_CGROUP|unix.PERF_FLAG_FD_CLOEXEC)
+			if err != nil {
+				counters.Close()
+				if err == unix.ENOENT || err == unix.EOPNOTSUPP {
+					return nil, fmt.Errorf("perf event %s is not supported on this host; %v", name, err)
+				}
+				return nil, fmt.Errorf("perf_event_open %s on cpu %d error; %v", name, cpu, err)
+			}
+			counters.fds[name] = append(counters.fds[name], fd)
+		}
+	}
+	return counters, nil
+}
+
+// Enable starts every counter.
+func (p *PerfCounters) Enable() error {
+	for name, fds := range p.fds {
+		for _, fd := range fds {
+			if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+				return fmt.Errorf("enable perf event %s error; %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Read sums the counters of every cpu, in the order the events were given.
+func (p *PerfCounters) Read() ([]*PerfCount, error) {
+	var counts []*PerfCount
+	// value, time_enabled and time_running in host byte order, see
+	// PERF_FORMAT_TOTAL_TIME_*.
+	var values [3]uint64
+	buf := (*[unsafe.Sizeof(values)]byte)(unsafe.Pointer(&values))[:]
+	for _, name := range p.events {
+		count := &PerfCount{Event: name}
+		for _, fd := range p.fds[name] {
+			if _, err := unix.Read(fd, buf); err != nil {
+				return nil, fmt.Errorf("read perf event %s error; %v", name, err)
+			}
+			value, enabled, running := values[0], values[1], values[2]
+			if running > 0 && running < enabled {
+				value = uint64(float64(value) * float64(enabled) / float64(running))
+			}
+			count.Value += value
+			count.Enabled += enabled
+			count.Running += running
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+func (p *PerfCounters) Close() {
+	for _, fds := range p.fds {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+	}
+	p.fds = map[string][]int{}
+}
+
+func (l *legacyManager) OpenPerfCounters(events []string) (*PerfCounters, error) {
+	dir, err := subsystems.GetCgroupPath("perf_event", l.path, false)
+	if err != nil {
+		return nil, fmt.Errorf("perf_event cgroup of %s error; %v", l.path, err)
+	}
+	return openPerfCounters(dir, events)
+}
+
+// OpenPerfCounters on cgroup v2 needs no controller, every cgroup can
+// scope perf events.
+func (u *unifiedManager) OpenPerfCounters(events []string) (*PerfCounters, error) {
+	return openPerfCounters(u.path, events)
+}
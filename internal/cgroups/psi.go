@@ -0,0 +1,250 @@
+package cgroups
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// the kernel accepts trigger windows between 500ms and 10s.
+	minPressureWindow = 500 * time.Millisecond
+	maxPressureWindow = 10 * time.Second
+
+	pressurePollTimeout = 1000
+)
+
+var errPressureUnsupported = errors.New("pressure stall information needs cgroup v2")
+
+// PressureResources are the resources the kernel tracks stalls for, each
+// one has a <resource>.pressure file in every v2 cgroup.
+var PressureResources = []string{"cpu", "memory", "io"}
+
+// PSIData is one line of a pressure file. The averages are the share of
+// time in percent some or all tasks stalled over the last 10s, 60s and
+// 300s, Total is the accumulated stall time in microseconds.
+type PSIData struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+	Total  uint64  `json:"total"`
+}
+
+// PSIStats holds the "some" and "full" lines of a pressure file, cpu only
+// has a full line since linux 5.13.
+type PSIStats struct {
+	Some PSIData `json:"some"`
+	Full PSIData `json:"full"`
+}
+
+// PressureStats holds the pressure of every resource of a cgroup.
+type PressureStats struct {
+	Cpu    *PSIStats `json:"cpu,omitempty"`
+	Memory *PSIStats `json:"memory,omitempty"`
+	Io     *PSIStats `json:"io,omitempty"`
+}
+
+// Get returns the pressure of resource, nil if it was not read.
+func (p *PressureStats) Get(resource string) *PSIStats {
+	switch resource {
+	case "cpu":
+		return p.Cpu
+	case "memory":
+		return p.Memory
+	case "io":
+		return p.Io
+	}
+	return nil
+}
+
+// PressureTrigger asks the kernel for an event whenever tasks stall on
+// Resource for more than Threshold within any Window.
+type PressureTrigger struct {
+	Resource  string
+	Type      string
+	Threshold time.Duration
+	Window    time.Duration
+}
+
+// ParsePressureTrigger parses "<resource>:<some|full>:<threshold>/<window>",
+// e.g. memory:some:150ms/1s.
+func ParsePressureTrigger(spec string) (*PressureTrigger, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid pressure trigger %s, expect <resource>:<some|full>:<threshold>/<window>", spec)
+	}
+	durations := strings.Split(parts[2], "/")
+	if len(durations) != 2 {
+		return nil, fmt.Errorf("invalid pressure trigger %s, expect <threshold>/<window>", spec)
+	}
+	threshold, err := time.ParseDuration(durations[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pressure threshold %s; %v", durations[0], err)
+	}
+	window, err := time.ParseDuration(durations[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pressure window %s; %v", durations[1], err)
+	}
+	trigger := &PressureTrigger{
+		Resource:  parts[0],
+		Type:      parts[1],
+		Threshold: threshold,
+		Window:    window,
+	}
+	if err := trigger.validate(); err != nil {
+		return nil, err
+	}
+	return trigger, nil
+}
+
+func (t *PressureTrigger) validate() error {
+	known := false
+	for _, resource := range PressureResources {
+		known = known || resource == t.Resource
+	}
+	if !known {
+		return fmt.Errorf("invalid pressure resource %s, must be one of %v", t.Resource, PressureResources)
+	}
+	if t.Type != "some" && t.Type != "full" {
+		return fmt.Errorf("invalid pressure type %s, must be some or full", t.Type)
+	}
+	if t.Window < minPressureWindow || t.Window > maxPressureWindow {
+		return fmt.Errorf("pressure window %s must be in [%s, %s]", t.Window, minPressureWindow, maxPressureWindow)
+	}
+	if t.Threshold <= 0 || t.Threshold > t.Window {
+		return fmt.Errorf("pressure threshold %s must be positive and within the window %s", t.Threshold, t.Window)
+	}
+	return nil
+}
+
+// String formats the trigger the way it is written to the pressure file,
+// both durations in microseconds.
+func (t *PressureTrigger) String() string {
+	return fmt.Sprintf("%s %d %d", t.Type, t.Threshold.Microseconds(), t.Window.Microseconds())
+}
+
+// ReadPSI parses a pressure file, its lines look like
+// "some avg10=0.12 avg60=0.05 avg300=0.01 total=123456".
+func ReadPSI(file string) (*PSIStats, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	stats := &PSIStats{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		var data *PSIData
+		switch fields[0] {
+		case "some":
+			data = &stats.Some
+		case "full":
+			data = &stats.Full
+		default:
+			continue
+		}
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid pressure line %q", line)
+			}
+			switch kv[0] {
+			case "avg10":
+				data.Avg10, err = strconv.ParseFloat(kv[1], 64)
+			case "avg60":
+				data.Avg60, err = strconv.ParseFloat(kv[1], 64)
+			case "avg300":
+				data.Avg300, err = strconv.ParseFloat(kv[1], 64)
+			case "total":
+				data.Total, err = strconv.ParseUint(kv[1], 10, 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid pressure line %q; %v", line, err)
+			}
+		}
+	}
+	return stats, nil
+}
+
+func (l *legacyManager) GetPressure() (*PressureStats, error) {
+	return nil, errPressureUnsupported
+}
+
+func (l *legacyManager) NotifyPressure(trigger *PressureTrigger) (<-chan struct{}, error) {
+	return nil, errPressureUnsupported
+}
+
+func (u *unifiedManager) GetPressure() (*PressureStats, error) {
+	stats := &PressureStats{}
+	for _, resource := range PressureResources {
+		psi, err := ReadPSI(path.Join(u.path, resource+".pressure"))
+		if err != nil {
+			return nil, fmt.Errorf("read %s.pressure fail; %v", resource, err)
+		}
+		switch resource {
+		case "cpu":
+			stats.Cpu = psi
+		case "memory":
+			stats.Memory = psi
+		case "io":
+			stats.Io = psi
+		}
+	}
+	return stats, nil
+}
+
+// NotifyPressure writes the trigger to the pressure file and polls it, the
+// kernel raises POLLPRI at most once per window while the stall stays
+// above the threshold. The trigger lives as long as the file is open.
+func (u *unifiedManager) NotifyPressure(trigger *PressureTrigger) (<-chan struct{}, error) {
+	if err := trigger.validate(); err != nil {
+		return nil, err
+	}
+	file := path.Join(u.path, trigger.Resource+".pressure")
+	fd, err := unix.Open(file, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open %s fail; %v", file, err)
+	}
+	if _, err := unix.Write(fd, []byte(trigger.String()+"\x00")); err != nil {
+		unix.Close(fd)
+		if err == unix.EINVAL && trigger.Window%(2*time.Second) != 0 {
+			return nil, fmt.Errorf("write pressure trigger %s to %s fail; %v, without CAP_SYS_RESOURCE the window must be a multiple of 2s", trigger, file, err)
+		}
+		return nil, fmt.Errorf("write pressure trigger %s to %s fail; %v", trigger, file, err)
+	}
+
+	events := make(chan struct{})
+	go func() {
+		defer close(events)
+		defer unix.Close(fd)
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLPRI}}
+		for {
+			n, err := unix.Poll(fds, pressurePollTimeout)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil || fds[0].Revents&unix.POLLERR != 0 {
+				return
+			}
+			// the kernel does not always wake pollers on rmdir.
+			if n == 0 {
+				if _, err := os.Stat(u.path); os.IsNotExist(err) {
+					return
+				}
+				continue
+			}
+			if fds[0].Revents&unix.POLLPRI != 0 {
+				events <- struct{}{}
+			}
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,46 @@
+package cgroups
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParsePressureTrigger(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *PressureTrigger
+		format  string
+		wantErr bool
+	}{
+		{"memory:some:150ms/1s", &PressureTrigger{"memory", "some", 150 * time.Millisecond, time.Second}, "some 150000 1000000", false},
+		{"cpu:full:1s/2s", &PressureTrigger{"cpu", "full", time.Second, 2 * time.Second}, "full 1000000 2000000", false},
+		{"io:some:1us/500ms", &PressureTrigger{"io", "some", time.Microsecond, 500 * time.Millisecond}, "some 1 500000", false},
+		{"io:some:10s/10s", &PressureTrigger{"io", "some", 10 * time.Second, 10 * time.Second}, "some 10000000 10000000", false},
+		{"memory:some:100ms/499ms", nil, "", true},
+		{"memory:some:100ms/10001ms", nil, "", true},
+		{"memory:some:0s/1s", nil, "", true},
+		{"memory:some:-1ms/1s", nil, "", true},
+		{"memory:some:2s/1s", nil, "", true},
+		{"disk:some:150ms/1s", nil, "", true},
+		{"memory:all:150ms/1s", nil, "", true},
+		{"memory:some:150/1s", nil, "", true},
+		{"memory:some:150ms", nil, "", true},
+		{"memory:some:150ms/1s/2s", nil, "", true},
+		{"memory:150ms/1s", nil, "", true},
+		{"", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePressureTrigger(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePressureTrigger(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParsePressureTrigger(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+		if got != nil && got.String() != tt.format {
+			t.Errorf("ParsePressureTrigger(%q).String() = %q, want %q", tt.input, got.String(), tt.format)
+		}
+	}
+}
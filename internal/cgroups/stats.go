@@ -0,0 +1,16 @@
+package cgroups
+
+import (
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+// Stats is a snapshot of the counters of one cgroup, controllers that are
+// not available on the host are left zero. Pressure is only read on cgroup v2.
+type Stats struct {
+	Cpu    subsystems.CpuacctStats `json:"cpu"`
+	Memory subsystems.MemoryStats  `json:"memory"`
+	Pids   subsystems.PidsStats    `json:"pids"`
+	Blkio  subsystems.BlkioStats   `json:"blkio"`
+
+	Pressure *PressureStats `json:"pressure,omitempty"`
+}
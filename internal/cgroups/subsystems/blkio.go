@@ -1,24 +1,178 @@
 package subsystems
 
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
 type BlkioSubsystem struct {
 }
 
+// BlkioDevice is a per device blkio setting, Value is a weight, a byte rate or an io rate.
+type BlkioDevice struct {
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
+	Value uint64 `json:"value"`
+}
+
+func (d *BlkioDevice) Key() string {
+	return fmt.Sprintf("%d:%d", d.Major, d.Minor)
+}
+
+func (d *BlkioDevice) String() string {
+	return fmt.Sprintf("%s %d", d.Key(), d.Value)
+}
+
+// BlkioStats holds the bytes read from and written to every device.
+type BlkioStats struct {
+	Read  uint64 `json:"read"`
+	Write uint64 `json:"write"`
+}
+
 func (b *BlkioSubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "blkio"
 }
 
 func (b *BlkioSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	subsysCgroupPath, err := GetCgroupPath(b.Name(), cgroupPath, true)
+	if err != nil {
+		return err
+	}
+	if res.BlkioWeight != 0 {
+		// kernels running blk-mq only have the bfq flavoured file.
+		weightFile := "blkio.weight"
+		if _, err := os.Stat(path.Join(subsysCgroupPath, weightFile)); os.IsNotExist(err) {
+			weightFile = "blkio.bfq.weight"
+		}
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, weightFile), []byte(strconv.FormatUint(uint64(res.BlkioWeight), 10)), 0644); err != nil {
+			return fmt.Errorf("set cgroup blkio weight fail %v", err)
+		}
+	}
+
+	throttles := []struct {
+		file    string
+		devices []*BlkioDevice
+	}{
+		{"blkio.weight_device", res.BlkioWeightDevice},
+		{"blkio.throttle.read_bps_device", res.BlkioDeviceReadBps},
+		{"blkio.throttle.write_bps_device", res.BlkioDeviceWriteBps},
+		{"blkio.throttle.read_iops_device", res.BlkioDeviceReadIOps},
+		{"blkio.throttle.write_iops_device", res.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, device := range throttle.devices {
+			// every write only updates the line of that device.
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, throttle.file), []byte(device.String()), 0644); err != nil {
+				return fmt.Errorf("set cgroup %s fail %v", throttle.file, err)
+			}
+		}
+	}
+	return nil
 }
 
 func (b *BlkioSubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(b.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (b *BlkioSubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(b.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// GetStats sums blkio.throttle.io_service_bytes, its lines look like "8:0 Read 4096".
+func (b *BlkioSubsystem) GetStats(cgroupPath string) (*BlkioStats, error) {
+	subsysCgroupPath, err := GetCgroupPath(b.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path.Join(subsysCgroupPath, "blkio.throttle.io_service_bytes"))
+	if err != nil {
+		return nil, fmt.Errorf("read blkio.throttle.io_service_bytes fail %v", err)
+	}
+	stats := &BlkioStats{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			stats.Read += value
+		case "Write":
+			stats.Write += value
+		}
+	}
+	return stats, nil
+}
+
+// ParseWeightDevice parses "/dev/sda:200".
+func ParseWeightDevice(spec string) (*BlkioDevice, error) {
+	return parseBlkioDevice(spec, ParseBlkioWeight)
+}
+
+// ParseRateDevice parses "/dev/sda:10mb", the rate is in bytes per second.
+func ParseRateDevice(spec string) (*BlkioDevice, error) {
+	return parseBlkioDevice(spec, ParseSize)
+}
+
+// ParseIOpsDevice parses "/dev/sda:1000", the rate is in io per second.
+func ParseIOpsDevice(spec string) (*BlkioDevice, error) {
+	return parseBlkioDevice(spec, func(value string) (uint64, error) {
+		return strconv.ParseUint(value, 10, 64)
+	})
+}
+
+// parseBlkioDevice splits "<device path>:<value>" and resolves the device to
+// its major:minor numbers.
+func parseBlkioDevice(spec string, parseValue func(string) (uint64, error)) (*BlkioDevice, error) {
+	idx := strings.LastIndex(spec, ":")
+	if idx <= 0 || idx == len(spec)-1 {
+		return nil, fmt.Errorf("invalid blkio device %s, expect <device>:<value>", spec)
+	}
+	devicePath, rawValue := spec[:idx], spec[idx+1:]
+	value, err := parseValue(rawValue)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blkio device %s; %v", spec, err)
+	}
+	major, minor, err := DeviceNumber(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	return &BlkioDevice{
+		Major: major,
+		Minor: minor,
+		Value: value,
+	}, nil
+}
+
+// DeviceNumber returns the major and minor numbers of a device node.
+func DeviceNumber(devicePath string) (uint32, uint32, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(devicePath, &stat); err != nil {
+		return 0, 0, fmt.Errorf("stat device %s error; %v", devicePath, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFBLK && stat.Mode&unix.S_IFMT != unix.S_IFCHR {
+		return 0, 0, fmt.Errorf("%s is not a device", devicePath)
+	}
+	return unix.Major(stat.Rdev), unix.Minor(stat.Rdev), nil
 }
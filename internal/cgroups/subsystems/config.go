@@ -0,0 +1,139 @@
+package subsystems
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// stringResourceConfig is the encoding of ResourceConfig before it was typed,
+// every value is kept as the user passed it on the command line.
+type stringResourceConfig struct {
+	MemoryLimit          string       `json:"memoryLimit,omitempty"`
+	MemorySwap           string       `json:"memorySwap,omitempty"`
+	MemoryReservation    string       `json:"memoryReservation,omitempty"`
+	MemorySwappiness     string       `json:"memorySwappiness,omitempty"`
+	KernelMemory         string       `json:"kernelMemory,omitempty"`
+	OomKillDisable       bool         `json:"oomKillDisable,omitempty"`
+	CpuShare             string       `json:"cpuShare,omitempty"`
+	CpuSet               string       `json:"cpuSet,omitempty"`
+	PidsLimit            string       `json:"pidsLimit,omitempty"`
+	CpuQuota             string       `json:"cpuQuota,omitempty"`
+	CpuPeriod            string       `json:"cpuPeriod,omitempty"`
+	BlkioWeight          string       `json:"blkioWeight,omitempty"`
+	BlkioWeightDevice    []string     `json:"blkioWeightDevice,omitempty"`
+	BlkioDeviceReadBps   []string     `json:"blkioDeviceReadBps,omitempty"`
+	BlkioDeviceWriteBps  []string     `json:"blkioDeviceWriteBps,omitempty"`
+	BlkioDeviceReadIOps  []string     `json:"blkioDeviceReadIOps,omitempty"`
+	BlkioDeviceWriteIOps []string     `json:"blkioDeviceWriteIOps,omitempty"`
+	HugetlbLimit         []string     `json:"hugetlbLimit,omitempty"`
+	Devices              []DeviceRule `json:"devices,omitempty"`
+}
+
+// UnmarshalJSON also accepts the string encoding that update stored in the
+// container state before the config was typed.
+func (r *ResourceConfig) UnmarshalJSON(data []byte) error {
+	type typedResourceConfig ResourceConfig
+	if err := json.Unmarshal(data, (*typedResourceConfig)(r)); err == nil {
+		return nil
+	}
+	old := stringResourceConfig{}
+	if err := json.Unmarshal(data, &old); err != nil {
+		return err
+	}
+	res, err := old.convert()
+	if err != nil {
+		return fmt.Errorf("convert string encoded resources error; %v", err)
+	}
+	*r = *res
+	return nil
+}
+
+func (s *stringResourceConfig) convert() (*ResourceConfig, error) {
+	res := &ResourceConfig{
+		OomKillDisable: s.OomKillDisable,
+		Devices:        s.Devices,
+	}
+	var err error
+	memories := []struct {
+		value string
+		field *int64
+	}{
+		{s.MemoryLimit, &res.MemoryLimit},
+		{s.MemorySwap, &res.MemorySwap},
+		{s.MemoryReservation, &res.MemoryReservation},
+		{s.KernelMemory, &res.KernelMemory},
+	}
+	for _, memory := range memories {
+		if memory.value == "" {
+			continue
+		}
+		if *memory.field, err = ParseMemory(memory.value); err != nil {
+			return nil, err
+		}
+	}
+	if s.MemorySwappiness != "" {
+		if res.MemorySwappiness, err = ParseSwappiness(s.MemorySwappiness); err != nil {
+			return nil, err
+		}
+	}
+	if s.CpuShare != "" {
+		if res.CpuShare, err = ParseCpuShares(s.CpuShare); err != nil {
+			return nil, err
+		}
+	}
+	if s.CpuSet != "" {
+		if res.CpuSet, err = ParseIdList(s.CpuSet); err != nil {
+			return nil, err
+		}
+	}
+	if s.PidsLimit != "" {
+		if res.PidsLimit, err = ParsePidsLimit(s.PidsLimit); err != nil {
+			return nil, err
+		}
+	}
+	if s.CpuQuota != "" {
+		if res.CpuQuota, err = ParseCpuQuota(s.CpuQuota); err != nil {
+			return nil, err
+		}
+	}
+	if s.CpuPeriod != "" {
+		if res.CpuPeriod, err = ParseCpuPeriod(s.CpuPeriod); err != nil {
+			return nil, err
+		}
+	}
+	if s.BlkioWeight != "" {
+		weight, err := ParseBlkioWeight(s.BlkioWeight)
+		if err != nil {
+			return nil, err
+		}
+		res.BlkioWeight = uint16(weight)
+	}
+	devices := []struct {
+		specs []string
+		parse func(string) (*BlkioDevice, error)
+		field *[]*BlkioDevice
+	}{
+		{s.BlkioWeightDevice, ParseWeightDevice, &res.BlkioWeightDevice},
+		{s.BlkioDeviceReadBps, ParseRateDevice, &res.BlkioDeviceReadBps},
+		{s.BlkioDeviceWriteBps, ParseRateDevice, &res.BlkioDeviceWriteBps},
+		{s.BlkioDeviceReadIOps, ParseIOpsDevice, &res.BlkioDeviceReadIOps},
+		{s.BlkioDeviceWriteIOps, ParseIOpsDevice, &res.BlkioDeviceWriteIOps},
+	}
+	for _, device := range devices {
+		for _, spec := range device.specs {
+			parsed, err := device.parse(spec)
+			if err != nil {
+				return nil, err
+			}
+			*device.field = append(*device.field, parsed)
+		}
+	}
+	for _, spec := range s.HugetlbLimit {
+		limit, err := ParseHugetlbLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		res.HugetlbLimit = append(res.HugetlbLimit, limit)
+	}
+	return res, nil
+}
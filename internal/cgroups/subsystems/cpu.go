@@ -17,11 +17,22 @@ func (c *CpuSubsystem) Name() string {
 
 func (c *CpuSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
-		if res.CpuShare != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
+		if res.CpuShare != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.shares"), []byte(strconv.FormatUint(res.CpuShare, 10)), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpu share fail %v", err)
 			}
 		}
+		// the period goes first, the quota is checked against it.
+		if res.CpuPeriod != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_period_us"), []byte(strconv.FormatUint(res.CpuPeriod, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu period fail %v", err)
+			}
+		}
+		if res.CpuQuota != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpu.cfs_quota_us"), []byte(strconv.FormatInt(res.CpuQuota, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpu quota fail %v", err)
+			}
+		}
 		return nil
 	} else {
 		return err
@@ -30,7 +41,7 @@ func (c *CpuSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 func (c *CpuSubsystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
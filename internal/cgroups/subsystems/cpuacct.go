@@ -1,24 +1,72 @@
 package subsystems
 
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"syscall"
+)
+
+// userHz is the unit of cpuacct.stat, the kernel always exports USER_HZ=100.
+const userHz = 100
+
 type CpuacctSubsystem struct {
 }
 
+// CpuacctStats holds the cpu time consumed by the cgroup in nanoseconds.
+type CpuacctStats struct {
+	Usage  uint64 `json:"usage"`
+	User   uint64 `json:"user"`
+	System uint64 `json:"system"`
+}
+
 func (c *CpuacctSubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "cpuacct"
 }
 
+// Set only creates the cgroup, cpuacct accounts and never limits.
 func (c *CpuacctSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	_, err := GetCgroupPath(c.Name(), cgroupPath, true)
+	return err
 }
 
 func (c *CpuacctSubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (c *CpuacctSubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// GetStats reads cpuacct.usage and the user/system split of cpuacct.stat.
+func (c *CpuacctSubsystem) GetStats(cgroupPath string) (*CpuacctStats, error) {
+	subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := ReadUint(path.Join(subsysCgroupPath, "cpuacct.usage"))
+	if err != nil {
+		return nil, fmt.Errorf("read cpuacct.usage fail %v", err)
+	}
+	stat, err := ReadKeyValues(path.Join(subsysCgroupPath, "cpuacct.stat"))
+	if err != nil {
+		return nil, fmt.Errorf("read cpuacct.stat fail %v", err)
+	}
+	return &CpuacctStats{
+		Usage:  usage,
+		User:   stat["user"] * (1e9 / userHz),
+		System: stat["system"] * (1e9 / userHz),
+	}, nil
 }
@@ -3,8 +3,11 @@ package subsystems
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"syscall"
 )
 
@@ -17,11 +20,31 @@ func (c *CpusetSubsystem) Name() string {
 
 func (c *CpusetSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
-		if res.CpuSet != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
+		if err := inheritCpuset(FindCgroupMountPoint(c.Name()), subsysCgroupPath); err != nil {
+			return err
+		}
+		parent := path.Dir(subsysCgroupPath)
+		cpus, err := readEffective(parent, "cpuset.effective_cpus", "cpuset.cpus")
+		if err != nil {
+			return fmt.Errorf("read parent cpuset fail %v", err)
+		}
+		mems, err := readEffective(parent, "cpuset.effective_mems", "cpuset.mems")
+		if err != nil {
+			return fmt.Errorf("read parent cpuset fail %v", err)
+		}
+		if err := ValidateCpuset(res, cpus, mems); err != nil {
+			return err
+		}
+		if len(res.CpuSet) > 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.cpus"), []byte(res.CpuSet.String()), 0644); err != nil {
 				return fmt.Errorf("set cgroup cpuset fail %v", err)
 			}
 		}
+		if len(res.CpuSetMems) > 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cpuset.mems"), []byte(res.CpuSetMems.String()), 0644); err != nil {
+				return fmt.Errorf("set cgroup cpuset mems fail %v", err)
+			}
+		}
 		return nil
 	} else {
 		return err
@@ -30,7 +53,7 @@ func (c *CpusetSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 
 func (c *CpusetSubsystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return fmt.Errorf("set cgroup proc fail %v", err)
 		}
 		return nil
@@ -46,3 +69,104 @@ func (c *CpusetSubsystem) Remove(cgroupPath string) error {
 		return err
 	}
 }
+
+// inheritCpuset fills the empty cpus and mems of every cgroup from root down
+// to dir with the ones of its parent, a v1 cpuset starts out empty and
+// refuses to take tasks until both are set.
+func inheritCpuset(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	current := root
+	for _, name := range strings.Split(rel, "/") {
+		if name == "." || name == "" {
+			continue
+		}
+		parent := current
+		current = path.Join(current, name)
+		for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+			content, err := ioutil.ReadFile(path.Join(current, file))
+			if err != nil {
+				return fmt.Errorf("read %s fail %v", file, err)
+			}
+			if strings.TrimSpace(string(content)) != "" {
+				continue
+			}
+			content, err = ioutil.ReadFile(path.Join(parent, file))
+			if err != nil {
+				return fmt.Errorf("read %s fail %v", file, err)
+			}
+			if err := ioutil.WriteFile(path.Join(current, file), content, 0644); err != nil {
+				return fmt.Errorf("inherit %s fail %v", file, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readEffective reads the effective set of a v1 cpuset, kernels before 4.17
+// only have the configured one.
+func readEffective(dir, effective, configured string) (IdList, error) {
+	list, err := ReadIdList(path.Join(dir, effective))
+	if os.IsNotExist(err) {
+		return ReadIdList(path.Join(dir, configured))
+	}
+	return list, err
+}
+
+// ValidateCpuset checks the cpus and mems of the resources against the
+// effective sets of the parent cgroup, the kernel refuses anything outside.
+func ValidateCpuset(res *ResourceConfig, cpus, mems IdList) error {
+	if !cpus.Contains(res.CpuSet) {
+		return fmt.Errorf("cpuset %s is not within the cpus %s of the parent cgroup", res.CpuSet, cpus)
+	}
+	if !mems.Contains(res.CpuSetMems) {
+		return fmt.Errorf("cpuset mems %s is not within the mems %s of the parent cgroup", res.CpuSetMems, mems)
+	}
+	return nil
+}
+
+// ReadIdList reads a cpu or node list file, an empty file is an empty list.
+func ReadIdList(file string) (IdList, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(content)) == "" {
+		return nil, nil
+	}
+	return ParseIdList(string(content))
+}
+
+// OnlineCpus returns the ids of the cpus the host currently runs.
+func OnlineCpus() (IdList, error) {
+	content, err := ioutil.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return nil, err
+	}
+	return ParseIdList(string(content))
+}
+
+// OnlineMems returns the ids of the numa nodes of the host, a kernel built
+// without numa has a single node 0.
+func OnlineMems() (IdList, error) {
+	content, err := ioutil.ReadFile("/sys/devices/system/node/online")
+	if os.IsNotExist(err) {
+		return IdList{0}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseIdList(string(content))
+}
+
+// NumaNodeCpus returns the cpus of a numa node.
+func NumaNodeCpus(node int) (IdList, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/sys/devices/system/node/node%d/cpulist", node))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("numa node %d does not exist", node)
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseIdList(string(content))
+}
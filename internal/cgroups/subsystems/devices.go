@@ -1,24 +1,187 @@
 package subsystems
 
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	BlockDevice = "b"
+	CharDevice  = "c"
+	AllDevices  = "a"
+
+	// Wildcard matches every major or minor number.
+	Wildcard int64 = -1
+)
+
+// DeviceRule is an entry of the device cgroup allowlist.
+type DeviceRule struct {
+	Type        string `json:"type"`
+	Major       int64  `json:"major"`
+	Minor       int64  `json:"minor"`
+	Permissions string `json:"permissions"`
+}
+
+// String formats the rule the way devices.allow expects it, e.g. "c 1:3 rwm".
+func (r DeviceRule) String() string {
+	return fmt.Sprintf("%s %s:%s %s", r.Type, deviceNumberString(r.Major), deviceNumberString(r.Minor), r.Permissions)
+}
+
+func deviceNumberString(number int64) string {
+	if number == Wildcard {
+		return "*"
+	}
+	return strconv.FormatInt(number, 10)
+}
+
+// DefaultAllowedDevices mirrors the runc defaults, every container may mknod
+// but only open the pseudo devices below.
+var DefaultAllowedDevices = []DeviceRule{
+	{Type: CharDevice, Major: Wildcard, Minor: Wildcard, Permissions: "m"},
+	{Type: BlockDevice, Major: Wildcard, Minor: Wildcard, Permissions: "m"},
+	// /dev/null
+	{Type: CharDevice, Major: 1, Minor: 3, Permissions: "rwm"},
+	// /dev/zero
+	{Type: CharDevice, Major: 1, Minor: 5, Permissions: "rwm"},
+	// /dev/full
+	{Type: CharDevice, Major: 1, Minor: 7, Permissions: "rwm"},
+	// /dev/random
+	{Type: CharDevice, Major: 1, Minor: 8, Permissions: "rwm"},
+	// /dev/urandom
+	{Type: CharDevice, Major: 1, Minor: 9, Permissions: "rwm"},
+	// /dev/tty
+	{Type: CharDevice, Major: 5, Minor: 0, Permissions: "rwm"},
+	// /dev/ptmx
+	{Type: CharDevice, Major: 5, Minor: 2, Permissions: "rwm"},
+	// /dev/pts/*
+	{Type: CharDevice, Major: 136, Minor: Wildcard, Permissions: "rwm"},
+	// /dev/fuse
+	{Type: CharDevice, Major: 10, Minor: 229, Permissions: "rwm"},
+}
+
+// AllowedDevices returns the default allowlist followed by the devices passed through by the user.
+func AllowedDevices(res *ResourceConfig) []DeviceRule {
+	rules := make([]DeviceRule, 0, len(DefaultAllowedDevices)+len(res.Devices))
+	rules = append(rules, DefaultAllowedDevices...)
+	return append(rules, res.Devices...)
+}
+
 type DevicesSubsystem struct {
 }
 
 func (d *DevicesSubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "devices"
 }
 
+// Set turns a new cgroup, which allows every device, into the allowlist by
+// denying every device first and opening up one rule at a time. On a cgroup
+// that already has an allowlist, e.g. on update, only the difference to the
+// current rules is written so the running processes never lose a device.
 func (d *DevicesSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, true); err == nil {
+		current, err := readDeviceList(path.Join(subsysCgroupPath, "devices.list"))
+		if err != nil {
+			return fmt.Errorf("read cgroup devices list fail %v", err)
+		}
+		wanted := deviceAccess(AllowedDevices(res))
+		if current[allDevicesKey] == "rwm" {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte(AllDevices), 0644); err != nil {
+				return fmt.Errorf("set cgroup devices deny fail %v", err)
+			}
+			current = map[string]string{}
+		}
+		for key, access := range current {
+			if extra := subtractAccess(access, wanted[key]); extra != "" {
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.deny"), []byte(key+" "+extra), 0644); err != nil {
+					return fmt.Errorf("set cgroup devices deny %s %s fail %v", key, extra, err)
+				}
+			}
+		}
+		for key, access := range wanted {
+			if missing := subtractAccess(access, current[key]); missing != "" {
+				if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "devices.allow"), []byte(key+" "+missing), 0644); err != nil {
+					return fmt.Errorf("set cgroup devices allow %s %s fail %v", key, missing, err)
+				}
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
+}
+
+// allDevicesKey is the only entry of devices.list while a cgroup allows
+// every device.
+const allDevicesKey = "a *:*"
+
+// readDeviceList reads devices.list into the access of each "type major:minor".
+func readDeviceList(file string) (map[string]string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	list := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		key := fields[0] + " " + fields[1]
+		list[key] = unionAccess(list[key], fields[2])
+	}
+	return list, nil
+}
+
+// deviceAccess merges the rules the way the kernel does, rules for the
+// same devices share one entry with the union of their access.
+func deviceAccess(rules []DeviceRule) map[string]string {
+	access := map[string]string{}
+	for _, rule := range rules {
+		key := fmt.Sprintf("%s %s:%s", rule.Type, deviceNumberString(rule.Major), deviceNumberString(rule.Minor))
+		access[key] = unionAccess(access[key], rule.Permissions)
+	}
+	return access
+}
+
+func unionAccess(a, b string) string {
+	union := ""
+	for _, c := range "rwm" {
+		if strings.ContainsRune(a, c) || strings.ContainsRune(b, c) {
+			union += string(c)
+		}
+	}
+	return union
+}
+
+func subtractAccess(a, b string) string {
+	rest := ""
+	for _, c := range "rwm" {
+		if strings.ContainsRune(a, c) && !strings.ContainsRune(b, c) {
+			rest += string(c)
+		}
+	}
+	return rest
 }
 
 func (d *DevicesSubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (d *DevicesSubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(d.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
 }
@@ -1,24 +1,80 @@
 package subsystems
 
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type FreezerState string
+
+const (
+	Frozen   FreezerState = "FROZEN"
+	Thawed   FreezerState = "THAWED"
+	Freezing FreezerState = "FREEZING"
+
+	// FreezerRetries and FreezerRetryInterval bound the wait for a cgroup
+	// to settle in a freezer state, 10s in total.
+	FreezerRetries       = 1000
+	FreezerRetryInterval = 10 * time.Millisecond
+)
+
 type FreezerSubsystem struct {
 }
 
 func (f *FreezerSubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "freezer"
 }
 
+// Set is a no-op, the freezer has no resource limit and is driven by Freeze.
 func (f *FreezerSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	_, err := GetCgroupPath(f.Name(), cgroupPath, true)
+	return err
 }
 
 func (f *FreezerSubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(f.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (f *FreezerSubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(f.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// Freeze writes state to freezer.state and waits until the kernel reports it,
+// a cgroup stays FREEZING while some task can not be stopped yet so the write is retried.
+func (f *FreezerSubsystem) Freeze(cgroupPath string, state FreezerState) error {
+	subsysCgroupPath, err := GetCgroupPath(f.Name(), cgroupPath, false)
+	if err != nil {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
+	stateFile := path.Join(subsysCgroupPath, "freezer.state")
+	for i := 0; i < FreezerRetries; i++ {
+		if err := ioutil.WriteFile(stateFile, []byte(state), 0644); err != nil {
+			return fmt.Errorf("set cgroup freezer state %s fail %v", state, err)
+		}
+		content, err := ioutil.ReadFile(stateFile)
+		if err != nil {
+			return fmt.Errorf("read cgroup freezer state fail %v", err)
+		}
+		if FreezerState(strings.TrimSpace(string(content))) == state {
+			return nil
+		}
+		time.Sleep(FreezerRetryInterval)
+	}
+	return fmt.Errorf("cgroup %s did not reach freezer state %s", cgroupPath, state)
 }
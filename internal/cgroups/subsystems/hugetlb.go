@@ -1,24 +1,121 @@
 package subsystems
 
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const hugePagesDir = "/sys/kernel/mm/hugepages"
+
 type HugetlbSubsystem struct {
 }
 
+// HugetlbLimit caps the usage of one huge page size, PageSize is named the
+// way the cgroup files are, e.g. 2MB or 1GB.
+type HugetlbLimit struct {
+	PageSize string `json:"pageSize"`
+	Limit    uint64 `json:"limit"`
+}
+
 func (h *HugetlbSubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "hugetlb"
 }
 
 func (h *HugetlbSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(h.Name(), cgroupPath, true); err == nil {
+		for _, limit := range res.HugetlbLimit {
+			file := fmt.Sprintf("hugetlb.%s.limit_in_bytes", limit.PageSize)
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, file), []byte(strconv.FormatUint(limit.Limit, 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup hugetlb limit fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
 }
 
 func (h *HugetlbSubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(h.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (h *HugetlbSubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(h.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// HugePageSizes lists the huge page sizes the kernel supports, it reads
+// the hugepages-<size>kB entries of /sys/kernel/mm/hugepages.
+func HugePageSizes() ([]string, error) {
+	files, err := ioutil.ReadDir(hugePagesDir)
+	if err != nil {
+		return nil, fmt.Errorf("read dir %s error; %v", hugePagesDir, err)
+	}
+	var sizes []string
+	for _, file := range files {
+		name := strings.TrimSuffix(strings.TrimPrefix(file.Name(), "hugepages-"), "kB")
+		kb, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		sizes = append(sizes, hugePageSizeName(kb<<10))
+	}
+	return sizes, nil
+}
+
+// hugePageSizeName formats a page size the way the kernel names the hugetlb files.
+func hugePageSizeName(size uint64) string {
+	switch {
+	case size >= 1<<30:
+		return fmt.Sprintf("%dGB", size>>30)
+	case size >= 1<<20:
+		return fmt.Sprintf("%dMB", size>>20)
+	}
+	return fmt.Sprintf("%dKB", size>>10)
+}
+
+// ParseHugetlbLimit parses "<page size>:<limit>", e.g. 2MB:512m, and checks
+// the page size is supported by the host.
+func ParseHugetlbLimit(spec string) (*HugetlbLimit, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid hugetlb limit %s, expect <page size>:<limit>", spec)
+	}
+	pageSize, err := ParseSize(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid hugetlb page size %s", parts[0])
+	}
+	limit, err := ParseSize(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid hugetlb limit %s", parts[1])
+	}
+
+	name := hugePageSizeName(pageSize)
+	sizes, err := HugePageSizes()
+	if err != nil {
+		return nil, err
+	}
+	for _, size := range sizes {
+		if size == name {
+			return &HugetlbLimit{
+				PageSize: name,
+				Limit:    limit,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("huge page size %s is not supported, supported sizes %v", parts[0], sizes)
 }
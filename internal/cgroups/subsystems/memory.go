@@ -3,24 +3,61 @@ package subsystems
 import (
 	"errors"
 	"fmt"
+	"github.com/sirupsen/logrus"
 	"io/ioutil"
+	"os"
 	"path"
 	"strconv"
 	"syscall"
 )
 
+// memoryUnlimited is the smallest value memory.limit_in_bytes reports when
+// no limit is set, the exact number depends on the page size.
+const memoryUnlimited = 1 << 62
+
+const minKernelMemory = 4 << 20
+
 type MemorySubsystem struct {
 }
 
+// MemoryStats holds the memory usage without the reclaimable page cache,
+// a zero Limit means unlimited.
+type MemoryStats struct {
+	Usage uint64 `json:"usage"`
+	Limit uint64 `json:"limit"`
+}
+
 func (m *MemorySubsystem) Name() string {
 	return "memory"
 }
 
 func (m *MemorySubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 	if subsysCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, true); err == nil {
-		if res.MemoryLimit != "" {
-			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644); err != nil {
-				return errors.New(fmt.Sprintf("set cgroup memory fail; %v", err))
+		if err := setMemoryAndSwap(subsysCgroupPath, res); err != nil {
+			return err
+		}
+		if res.MemoryReservation != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.soft_limit_in_bytes"), []byte(strconv.FormatInt(res.MemoryReservation, 10)), 0644); err != nil {
+				return errors.New(fmt.Sprintf("set cgroup memory reservation fail; %v", err))
+			}
+		}
+		if res.MemorySwappiness != nil {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.swappiness"), []byte(strconv.FormatUint(*res.MemorySwappiness, 10)), 0644); err != nil {
+				return errors.New(fmt.Sprintf("set cgroup memory swappiness fail; %v", err))
+			}
+		}
+		if res.KernelMemory != 0 {
+			// kmem accounting is gone since linux 5.16, the file no longer exists there.
+			kmemFile := path.Join(subsysCgroupPath, "memory.kmem.limit_in_bytes")
+			if _, err := os.Stat(kmemFile); err != nil {
+				logrus.Warnf("kernel memory limit is not supported by this kernel, ignore it")
+			} else if err := ioutil.WriteFile(kmemFile, []byte(strconv.FormatInt(res.KernelMemory, 10)), 0644); err != nil {
+				return errors.New(fmt.Sprintf("set cgroup kernel memory fail; %v", err))
+			}
+		}
+		if res.OomKillDisable {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "memory.oom_control"), []byte("1"), 0644); err != nil {
+				return errors.New(fmt.Sprintf("set cgroup oom control fail; %v", err))
 			}
 		}
 		return nil
@@ -29,9 +66,43 @@ func (m *MemorySubsystem) Set(cgroupPath string, res *ResourceConfig) error {
 	}
 }
 
+// setMemoryAndSwap writes memory.limit_in_bytes and memory.memsw.limit_in_bytes,
+// the kernel keeps memsw >= limit at any time so when the limit is raised
+// above the current memsw the memsw has to go first.
+func setMemoryAndSwap(subsysCgroupPath string, res *ResourceConfig) error {
+	limitFile := path.Join(subsysCgroupPath, "memory.limit_in_bytes")
+	swapFile := path.Join(subsysCgroupPath, "memory.memsw.limit_in_bytes")
+	var limit, swap string
+	if res.MemoryLimit != 0 {
+		limit = strconv.FormatInt(res.MemoryLimit, 10)
+	}
+	if res.MemorySwap != 0 {
+		swap = strconv.FormatInt(res.MemorySwap, 10)
+	}
+
+	if limit != "" {
+		if err := ioutil.WriteFile(limitFile, []byte(limit), 0644); err == nil {
+			limit = ""
+		} else if swap == "" {
+			return errors.New(fmt.Sprintf("set cgroup memory fail; %v", err))
+		}
+	}
+	if swap != "" {
+		if err := ioutil.WriteFile(swapFile, []byte(swap), 0644); err != nil {
+			return errors.New(fmt.Sprintf("set cgroup memory swap fail; %v", err))
+		}
+	}
+	if limit != "" {
+		if err := ioutil.WriteFile(limitFile, []byte(limit), 0644); err != nil {
+			return errors.New(fmt.Sprintf("set cgroup memory fail; %v", err))
+		}
+	}
+	return nil
+}
+
 func (m *MemorySubsystem) Apply(cgroupPath string, pid int) error {
 	if subsysCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, false); err == nil {
-		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
 			return errors.New(fmt.Sprintf("set cgroup proc fail; %v", err))
 		}
 		return nil
@@ -47,3 +118,32 @@ func (m *MemorySubsystem) Remove(cgroupPath string) error {
 		return err
 	}
 }
+
+// GetStats reads memory.usage_in_bytes and memory.limit_in_bytes, inactive
+// file pages are subtracted from the usage the way docker does.
+func (m *MemorySubsystem) GetStats(cgroupPath string) (*MemoryStats, error) {
+	subsysCgroupPath, err := GetCgroupPath(m.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := ReadUint(path.Join(subsysCgroupPath, "memory.usage_in_bytes"))
+	if err != nil {
+		return nil, fmt.Errorf("read memory.usage_in_bytes fail; %v", err)
+	}
+	limit, err := ReadUint(path.Join(subsysCgroupPath, "memory.limit_in_bytes"))
+	if err != nil {
+		return nil, fmt.Errorf("read memory.limit_in_bytes fail; %v", err)
+	}
+	if limit >= memoryUnlimited {
+		limit = 0
+	}
+	if stat, err := ReadKeyValues(path.Join(subsysCgroupPath, "memory.stat")); err == nil {
+		if inactive := stat["total_inactive_file"]; inactive < usage {
+			usage -= inactive
+		}
+	}
+	return &MemoryStats{
+		Usage: usage,
+		Limit: limit,
+	}, nil
+}
@@ -1,24 +1,77 @@
 package subsystems
 
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
 type NetClassifierSubsystem struct {
 }
 
 func (n *NetClassifierSubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "net_cls"
 }
 
+// Set writes net_cls.classid, every packet sent by a task of the cgroup
+// carries it and the tc cgroup filter maps it onto a tc class.
 func (n *NetClassifierSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(n.Name(), cgroupPath, true); err == nil {
+		if res.NetClassId != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "net_cls.classid"), []byte(strconv.FormatUint(uint64(res.NetClassId), 10)), 0644); err != nil {
+				return fmt.Errorf("set cgroup net classid fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
 }
 
 func (n *NetClassifierSubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(n.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (n *NetClassifierSubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(n.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// ParseNetClassId parses a classid in the tc notation "<major>:<minor>",
+// both hexadecimal as tc prints them, e.g. 10:1 is 0x00100001.
+func ParseNetClassId(classId string) (uint32, error) {
+	parts := strings.Split(classId, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid net classid %s, expect <major>:<minor>", classId)
+	}
+	major, err := strconv.ParseUint(parts[0], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid net classid major %s", parts[0])
+	}
+	minor, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid net classid minor %s", parts[1])
+	}
+	if major == 0 {
+		return 0, fmt.Errorf("invalid net classid %s, major must not be 0", classId)
+	}
+	return uint32(major<<16 | minor), nil
+}
+
+// FormatNetClassId formats a classid back into the tc notation.
+func FormatNetClassId(classId uint32) string {
+	return fmt.Sprintf("%x:%x", classId>>16, classId&0xffff)
 }
@@ -0,0 +1,38 @@
+package subsystems
+
+import "testing"
+
+func TestParseNetClassId(t *testing.T) {
+	valid := map[string]uint32{
+		"10:1":      0x00100001,
+		"1:0":       0x00010000,
+		"ffff:ffff": 0xffffffff,
+		"FFFF:A":    0xffff000a,
+		"0010:0001": 0x00100001,
+	}
+	for input, want := range valid {
+		got, err := ParseNetClassId(input)
+		if err != nil || got != want {
+			t.Errorf("ParseNetClassId(%q) = %#x, %v, want %#x", input, got, err, want)
+		}
+	}
+
+	invalid := []string{"0:1", "10000:1", "1:10000", "g:1", "1:-1", "10", "1:2:3", ":1", "1:", ""}
+	for _, input := range invalid {
+		if got, err := ParseNetClassId(input); err == nil {
+			t.Errorf("ParseNetClassId(%q) = %#x, want an error", input, got)
+		}
+	}
+}
+
+func TestFormatNetClassId(t *testing.T) {
+	for _, classId := range []uint32{0x00100001, 0x00010000, 0xffffffff, 0xffff000a} {
+		formatted := FormatNetClassId(classId)
+		if got, err := ParseNetClassId(formatted); err != nil || got != classId {
+			t.Errorf("ParseNetClassId(FormatNetClassId(%#x) = %q) = %#x, %v", classId, formatted, got, err)
+		}
+	}
+	if got := FormatNetClassId(0x00100001); got != "10:1" {
+		t.Errorf("FormatNetClassId(0x100001) = %q, want %q", got, "10:1")
+	}
+}
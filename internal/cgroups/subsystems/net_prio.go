@@ -1,24 +1,83 @@
 package subsystems
 
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
 type NetPrioritySubsystem struct {
 }
 
+// IfPrioMap sets the priority of the packets a cgroup sends through an interface.
+type IfPrioMap struct {
+	Interface string `json:"interface"`
+	Priority  uint32 `json:"priority"`
+}
+
+func (i *IfPrioMap) String() string {
+	return fmt.Sprintf("%s %d", i.Interface, i.Priority)
+}
+
 func (n *NetPrioritySubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "net_prio"
 }
 
 func (n *NetPrioritySubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(n.Name(), cgroupPath, true); err == nil {
+		for _, prioMap := range res.NetPrioIfpriomap {
+			// every write only updates the line of that interface.
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "net_prio.ifpriomap"), []byte(prioMap.String()), 0644); err != nil {
+				return fmt.Errorf("set cgroup net prio of %s fail %v", prioMap.Interface, err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
 }
 
 func (n *NetPrioritySubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(n.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (n *NetPrioritySubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(n.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+// ParseIfPrioMap parses "<interface>:<priority>". The kernel resolves the
+// interface in the network namespace of the writer, so it names an
+// interface of the host.
+func ParseIfPrioMap(spec string) (*IfPrioMap, error) {
+	idx := strings.LastIndex(spec, ":")
+	if idx <= 0 || idx == len(spec)-1 {
+		return nil, fmt.Errorf("invalid net prio %s, expect <interface>:<priority>", spec)
+	}
+	ifName, rawPrio := spec[:idx], spec[idx+1:]
+	prio, err := strconv.ParseUint(rawPrio, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid net prio %s; %v", spec, err)
+	}
+	if _, err := net.InterfaceByName(ifName); err != nil {
+		return nil, fmt.Errorf("invalid net prio %s; %v", spec, err)
+	}
+	return &IfPrioMap{
+		Interface: ifName,
+		Priority:  uint32(prio),
+	}, nil
 }
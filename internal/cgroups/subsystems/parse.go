@@ -0,0 +1,256 @@
+package subsystems
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	minCpuShare uint64 = 2
+	maxCpuShare uint64 = 262144
+
+	// DefaultCpuPeriod is the kernel default cfs period, 100ms.
+	DefaultCpuPeriod uint64 = 100000
+	minCpuPeriod     uint64 = 1000
+	maxCpuPeriod     uint64 = 1000000
+	minCpuQuota      int64  = 1000
+
+	minBlkioWeight = 10
+	maxBlkioWeight = 1000
+
+	// maxListId bounds the ids of a list, the kernel supports at most 8192
+	// cpus and fewer numa nodes.
+	maxListId = 8191
+)
+
+// sizeUnits are binary, 1k and 1KiB are both 1024 bytes.
+var sizeUnits = map[string]uint64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// ParseSize parses a human readable size into bytes. It takes a plain number
+// or a number with a k, m, g or t unit, optionally followed by b or ib in any
+// case, e.g. 512, 10k, 100mb, 1.5G or 64MiB.
+func ParseSize(size string) (uint64, error) {
+	value := strings.ToLower(strings.TrimSpace(size))
+	if strings.HasSuffix(value, "ib") {
+		value = strings.TrimSuffix(value, "ib")
+		if value == "" || !strings.ContainsAny(value[len(value)-1:], "kmgt") {
+			return 0, fmt.Errorf("invalid size %s", size)
+		}
+	} else {
+		value = strings.TrimSuffix(value, "b")
+	}
+
+	unit := ""
+	if value != "" && strings.ContainsAny(value[len(value)-1:], "kmgt") {
+		unit = value[len(value)-1:]
+		value = value[:len(value)-1]
+	}
+	if value == "" || strings.ContainsAny(value, "+-") {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	if n, err := strconv.ParseUint(value, 10, 64); err == nil {
+		if n > math.MaxUint64/sizeUnits[unit] {
+			return 0, fmt.Errorf("size %s is too large", size)
+		}
+		return n * sizeUnits[unit], nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("invalid size %s", size)
+	}
+	bytes := f * float64(sizeUnits[unit])
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %s is too large", size)
+	}
+	return uint64(bytes), nil
+}
+
+// FormatSize prints a byte count with a binary unit, whole numbers of the
+// unit without decimals, e.g. 512B, 100MiB or 12.50MiB.
+func FormatSize(size uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	exact := true
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		exact = exact && size%(1<<(10*uint(i+1))) == 0
+		value /= 1024
+		i++
+	}
+	if exact {
+		return fmt.Sprintf("%d%s", size>>(10*uint(i)), units[i])
+	}
+	return fmt.Sprintf("%.2f%s", value, units[i])
+}
+
+// ParseMemory parses a memory size, -1 stands for unlimited.
+func ParseMemory(value string) (int64, error) {
+	if strings.TrimSpace(value) == "-1" {
+		return -1, nil
+	}
+	size, err := ParseSize(value)
+	if err != nil {
+		return 0, err
+	}
+	if size > math.MaxInt64 {
+		return 0, fmt.Errorf("size %s is too large", value)
+	}
+	return int64(size), nil
+}
+
+// IdList is a set of cpu or memory node ids, written in the kernel list
+// format such as "0-3,8,10-11".
+type IdList []int
+
+// ParseIdList parses the kernel list format, the result is sorted and
+// every id appears once.
+func ParseIdList(list string) (IdList, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, fmt.Errorf("empty list")
+	}
+	seen := map[int]bool{}
+	var ids IdList
+	for _, part := range strings.Split(list, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil || start < 0 {
+			return nil, fmt.Errorf("invalid list %s", list)
+		}
+		end := start
+		if len(bounds) == 2 {
+			end, err = strconv.Atoi(bounds[1])
+			if err != nil || end < start {
+				return nil, fmt.Errorf("invalid list %s", list)
+			}
+		}
+		if end > maxListId {
+			return nil, fmt.Errorf("invalid list %s, ids must not exceed %d", list, maxListId)
+		}
+		for id := start; id <= end; id++ {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// String formats the ids back into the kernel list format, consecutive ids
+// are folded into ranges.
+func (l IdList) String() string {
+	var parts []string
+	for i := 0; i < len(l); {
+		j := i
+		for j+1 < len(l) && l[j+1] == l[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(l[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", l[i], l[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Contains reports whether every id of other is in l.
+func (l IdList) Contains(other IdList) bool {
+	set := map[int]bool{}
+	for _, id := range l {
+		set[id] = true
+	}
+	for _, id := range other {
+		if !set[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCpuShares parses a cpu.shares value in [2, 262144].
+func ParseCpuShares(value string) (uint64, error) {
+	shares, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+	if err != nil || shares < minCpuShare || shares > maxCpuShare {
+		return 0, fmt.Errorf("invalid cpu share %s, must be in [%d, %d]", value, minCpuShare, maxCpuShare)
+	}
+	return shares, nil
+}
+
+// ParseBlkioWeight parses a blkio weight in [10, 1000].
+func ParseBlkioWeight(value string) (uint64, error) {
+	weight, err := strconv.ParseUint(strings.TrimSpace(value), 10, 16)
+	if err != nil || weight < minBlkioWeight || weight > maxBlkioWeight {
+		return 0, fmt.Errorf("invalid blkio weight %s, must be in [%d, %d]", value, minBlkioWeight, maxBlkioWeight)
+	}
+	return weight, nil
+}
+
+// ParsePidsLimit parses a pids limit, zero or a negative number lifts the limit.
+func ParsePidsLimit(value string) (int64, error) {
+	limit, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pids limit %s", value)
+	}
+	if limit <= 0 {
+		return -1, nil
+	}
+	return limit, nil
+}
+
+// ParseSwappiness parses a swappiness in [0, 100], -1 keeps the kernel default.
+func ParseSwappiness(value string) (*uint64, error) {
+	swappiness, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || swappiness < -1 || swappiness > 100 {
+		return nil, fmt.Errorf("invalid memory swappiness %s, must be in [0, 100] or -1", value)
+	}
+	if swappiness == -1 {
+		return nil, nil
+	}
+	result := uint64(swappiness)
+	return &result, nil
+}
+
+// ParseCpuPeriod parses a cfs period in microseconds, in [1000, 1000000].
+func ParseCpuPeriod(value string) (uint64, error) {
+	period, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+	if err != nil || period < minCpuPeriod || period > maxCpuPeriod {
+		return 0, fmt.Errorf("invalid cpu period %s, must be in [%d, %d]", value, minCpuPeriod, maxCpuPeriod)
+	}
+	return period, nil
+}
+
+// ParseCpuQuota parses a cfs quota in microseconds, -1 or at least 1000.
+func ParseCpuQuota(value string) (int64, error) {
+	quota, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || (quota != -1 && quota < minCpuQuota) {
+		return 0, fmt.Errorf("invalid cpu quota %s, must be -1 or at least %d", value, minCpuQuota)
+	}
+	return quota, nil
+}
+
+// ParseCpus turns a fractional number of cpus into a cfs quota for period,
+// e.g. 1.5 cpus with the default period is a quota of 150000.
+func ParseCpus(value string, period uint64) (int64, error) {
+	cpus, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || cpus <= 0 || math.IsInf(cpus, 0) || math.IsNaN(cpus) {
+		return 0, fmt.Errorf("invalid cpus %s", value)
+	}
+	quota := int64(math.Round(cpus * float64(period)))
+	if quota < minCpuQuota {
+		return 0, fmt.Errorf("cpus %s is too small, the quota must be at least %dus", value, minCpuQuota)
+	}
+	return quota, nil
+}
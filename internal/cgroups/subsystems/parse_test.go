@@ -0,0 +1,389 @@
+package subsystems
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512b", 512, false},
+		{"10k", 10 << 10, false},
+		{"10K", 10 << 10, false},
+		{"10kb", 10 << 10, false},
+		{"10KiB", 10 << 10, false},
+		{"100m", 100 << 20, false},
+		{"100MB", 100 << 20, false},
+		{"64MiB", 64 << 20, false},
+		{"1g", 1 << 30, false},
+		{"1.5g", 3 << 29, false},
+		{"2t", 2 << 40, false},
+		{" 4m ", 4 << 20, false},
+		{"", 0, true},
+		{"m", 0, true},
+		{"ib", 0, true},
+		{"10ib", 0, true},
+		{"-1", 0, true},
+		{"+1m", 0, true},
+		{"10x", 0, true},
+		{"1.2.3m", 0, true},
+		{"99999999999t", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"-1", -1, false},
+		{"256m", 256 << 20, false},
+		{"-2", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMemory(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseMemory(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMemory(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseIdList(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    IdList
+		format  string
+		wantErr bool
+	}{
+		{"0", IdList{0}, "0", false},
+		{"0-3", IdList{0, 1, 2, 3}, "0-3", false},
+		{"0-1,3", IdList{0, 1, 3}, "0-1,3", false},
+		{"8,0-2,1", IdList{0, 1, 2, 8}, "0-2,8", false},
+		{"4,5,6,10-11\n", IdList{4, 5, 6, 10, 11}, "4-6,10-11", false},
+		{"", nil, "", true},
+		{"3-1", nil, "", true},
+		{"-1", nil, "", true},
+		{"a", nil, "", true},
+		{"1,,2", nil, "", true},
+		{"8190-8191", IdList{8190, 8191}, "8190-8191", false},
+		{"8192", nil, "", true},
+		{"0-4000000000", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseIdList(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseIdList(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseIdList(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+		if got.String() != tt.format {
+			t.Errorf("ParseIdList(%q).String() = %q, want %q", tt.input, got.String(), tt.format)
+		}
+	}
+}
+
+func TestIdListContains(t *testing.T) {
+	tests := []struct {
+		list  IdList
+		other IdList
+		want  bool
+	}{
+		{IdList{0, 1, 2, 3}, IdList{1, 3}, true},
+		{IdList{0, 1}, nil, true},
+		{IdList{0, 1}, IdList{2}, false},
+		{nil, IdList{0}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.list.Contains(tt.other); got != tt.want {
+			t.Errorf("%v.Contains(%v) = %v, want %v", tt.list, tt.other, got, tt.want)
+		}
+	}
+}
+
+func TestParseCpuShares(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{"2", 2, false},
+		{"1024", 1024, false},
+		{"262144", 262144, false},
+		{"1", 0, true},
+		{"262145", 0, true},
+		{"-1", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCpuShares(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCpuShares(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCpuShares(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseBlkioWeight(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{"10", 10, false},
+		{"500", 500, false},
+		{"1000", 1000, false},
+		{"9", 0, true},
+		{"1001", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseBlkioWeight(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseBlkioWeight(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseBlkioWeight(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParsePidsLimit(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"100", 100, false},
+		{"0", -1, false},
+		{"-1", -1, false},
+		{"1.5", 0, true},
+		{"abc", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePidsLimit(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePidsLimit(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePidsLimit(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseSwappiness(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    *uint64
+		wantErr bool
+	}{
+		{"0", uint64Ptr(0), false},
+		{"60", uint64Ptr(60), false},
+		{"100", uint64Ptr(100), false},
+		{"-1", nil, false},
+		{"101", nil, true},
+		{"-2", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSwappiness(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSwappiness(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSwappiness(%q) = %v, want %v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseCpuPeriodAndQuota(t *testing.T) {
+	periods := []struct {
+		input   string
+		want    uint64
+		wantErr bool
+	}{
+		{"1000", 1000, false},
+		{"100000", 100000, false},
+		{"1000000", 1000000, false},
+		{"999", 0, true},
+		{"1000001", 0, true},
+	}
+	for _, tt := range periods {
+		got, err := ParseCpuPeriod(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCpuPeriod(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCpuPeriod(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+
+	quotas := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"-1", -1, false},
+		{"1000", 1000, false},
+		{"50000", 50000, false},
+		{"999", 0, true},
+		{"0", 0, true},
+		{"-2", 0, true},
+	}
+	for _, tt := range quotas {
+		got, err := ParseCpuQuota(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCpuQuota(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCpuQuota(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestParseCpus(t *testing.T) {
+	tests := []struct {
+		input   string
+		period  uint64
+		want    int64
+		wantErr bool
+	}{
+		{"1", DefaultCpuPeriod, 100000, false},
+		{"1.5", DefaultCpuPeriod, 150000, false},
+		{"0.5", 50000, 25000, false},
+		{"0.01", DefaultCpuPeriod, 1000, false},
+		{"0.001", DefaultCpuPeriod, 0, true},
+		{"0", DefaultCpuPeriod, 0, true},
+		{"-1", DefaultCpuPeriod, 0, true},
+		{"abc", DefaultCpuPeriod, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseCpus(tt.input, tt.period)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCpus(%q, %d) error = %v, wantErr %v", tt.input, tt.period, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCpus(%q, %d) = %d, want %d", tt.input, tt.period, got, tt.want)
+		}
+	}
+}
+
+func TestValidateCpuAgainstCpuset(t *testing.T) {
+	online, err := OnlineCpus()
+	if err != nil {
+		t.Skipf("read online cpus: %v", err)
+	}
+	tests := []struct {
+		name    string
+		res     ResourceConfig
+		wantErr bool
+	}{
+		{"empty", ResourceConfig{}, false},
+		{"one online cpu", ResourceConfig{CpuSet: online[:1]}, false},
+		{"offline cpu", ResourceConfig{CpuSet: IdList{online[len(online)-1] + 1}}, true},
+		{"quota fits cpuset", ResourceConfig{CpuSet: online[:1], CpuQuota: 50000}, false},
+		{"quota exceeds cpuset", ResourceConfig{CpuSet: online[:1], CpuQuota: 150000}, true},
+		{"quota exceeds host", ResourceConfig{CpuQuota: int64(len(online)+1) * 100000}, true},
+		{"unlimited quota", ResourceConfig{CpuQuota: -1}, false},
+	}
+	for _, tt := range tests {
+		err := tt.res.validateCpu()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateCpu() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateMemory(t *testing.T) {
+	total, err := HostMemoryTotal()
+	if err != nil {
+		t.Skipf("read host memory: %v", err)
+	}
+	tests := []struct {
+		name    string
+		res     ResourceConfig
+		wantErr bool
+	}{
+		{"empty", ResourceConfig{}, false},
+		{"limit", ResourceConfig{MemoryLimit: 64 << 20}, false},
+		{"limit below minimum", ResourceConfig{MemoryLimit: 1 << 20}, true},
+		{"limit above host", ResourceConfig{MemoryLimit: int64(total) + 1<<30}, true},
+		{"swap unlimited", ResourceConfig{MemoryLimit: 64 << 20, MemorySwap: -1}, false},
+		{"swap without limit", ResourceConfig{MemorySwap: 64 << 20}, true},
+		{"swap below limit", ResourceConfig{MemoryLimit: 64 << 20, MemorySwap: 32 << 20}, true},
+		{"reservation above limit", ResourceConfig{MemoryLimit: 64 << 20, MemoryReservation: 128 << 20}, true},
+		{"kernel memory below minimum", ResourceConfig{KernelMemory: 1 << 20}, true},
+		{"swappiness out of range", ResourceConfig{MemorySwappiness: uint64Ptr(101)}, true},
+	}
+	for _, tt := range tests {
+		err := tt.res.validateMemory()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateMemory() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResourceConfigUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    ResourceConfig
+		wantErr bool
+	}{
+		{"typed", `{"memoryLimit":104857600,"cpuSet":[0,1],"pidsLimit":-1}`,
+			ResourceConfig{MemoryLimit: 100 << 20, CpuSet: IdList{0, 1}, PidsLimit: -1}, false},
+		{"string encoded", `{"memoryLimit":"100m","memorySwap":"-1","cpuShare":"512","cpuSet":"0-1","pidsLimit":"0","cpuQuota":"50000","cpuPeriod":"100000","oomKillDisable":true}`,
+			ResourceConfig{MemoryLimit: 100 << 20, MemorySwap: -1, CpuShare: 512, CpuSet: IdList{0, 1}, PidsLimit: -1,
+				CpuQuota: 50000, CpuPeriod: 100000, OomKillDisable: true}, false},
+		{"string swappiness", `{"memorySwappiness":"60"}`, ResourceConfig{MemorySwappiness: uint64Ptr(60)}, false},
+		{"invalid string", `{"cpuShare":"1"}`, ResourceConfig{}, true},
+		{"invalid json", `{"memoryLimit":}`, ResourceConfig{}, true},
+	}
+	for _, tt := range tests {
+		var got ResourceConfig
+		err := json.Unmarshal([]byte(tt.data), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Unmarshal error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Unmarshal = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func uint64Ptr(value uint64) *uint64 {
+	return &value
+}
@@ -1,24 +1,42 @@
 package subsystems
 
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"syscall"
+)
+
 type PerfEventSubsystem struct {
 }
 
 func (p *PerfEventSubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "perf_event"
 }
 
+// Set only creates the cgroup, perf_event has no resource limit and is read
+// through cgroup scoped perf_event_open counters.
 func (p *PerfEventSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	_, err := GetCgroupPath(p.Name(), cgroupPath, true)
+	return err
 }
 
 func (p *PerfEventSubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (p *PerfEventSubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
 }
@@ -1,24 +1,101 @@
 package subsystems
 
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
 type PidsSubsystem struct {
 }
 
+// PidsStats holds the counters of the pids controller, Events is the number
+// of times a fork was refused because pids.max was reached.
+type PidsStats struct {
+	Current uint64 `json:"current"`
+	Limit   uint64 `json:"limit"`
+	Events  uint64 `json:"events"`
+}
+
 func (p *PidsSubsystem) Name() string {
-	//TODO implement me
-	panic("implement me")
+	return "pids"
 }
 
 func (p *PidsSubsystem) Set(cgroupPath string, res *ResourceConfig) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, true); err == nil {
+		if res.PidsLimit != 0 {
+			if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "pids.max"), []byte(PidsMax(res.PidsLimit)), 0644); err != nil {
+				return fmt.Errorf("set cgroup pids limit fail %v", err)
+			}
+		}
+		return nil
+	} else {
+		return err
+	}
 }
 
 func (p *PidsSubsystem) Apply(cgroupPath string, pid int) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(subsysCgroupPath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			return fmt.Errorf("set cgroup proc fail %v", err)
+		}
+		return nil
+	} else {
+		return fmt.Errorf("get cgroup %s error: %v", cgroupPath, err)
+	}
 }
 
 func (p *PidsSubsystem) Remove(cgroupPath string) error {
-	//TODO implement me
-	panic("implement me")
+	if subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, false); err == nil {
+		return syscall.Rmdir(subsysCgroupPath)
+	} else {
+		return err
+	}
+}
+
+func (p *PidsSubsystem) GetStats(cgroupPath string) (*PidsStats, error) {
+	subsysCgroupPath, err := GetCgroupPath(p.Name(), cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	return ReadPidsStats(subsysCgroupPath)
+}
+
+// PidsMax converts the limit into the pids.max value, a negative limit
+// lifts it.
+func PidsMax(limit int64) string {
+	if limit < 0 {
+		return "max"
+	}
+	return strconv.FormatInt(limit, 10)
+}
+
+// ReadPidsStats reads pids.current, pids.max and pids.events from dir, the
+// files are named the same on cgroup v1 and v2.
+func ReadPidsStats(dir string) (*PidsStats, error) {
+	stats := &PidsStats{}
+	current, err := ReadUint(path.Join(dir, "pids.current"))
+	if err != nil {
+		return nil, fmt.Errorf("read pids.current fail %v", err)
+	}
+	stats.Current = current
+
+	limit, err := ReadUint(path.Join(dir, "pids.max"))
+	if err != nil {
+		return nil, fmt.Errorf("read pids.max fail %v", err)
+	}
+	stats.Limit = limit
+
+	// pids.events only has a single "max <count>" line.
+	events, err := ioutil.ReadFile(path.Join(dir, "pids.events"))
+	if err == nil {
+		fields := strings.Fields(string(events))
+		if len(fields) == 2 && fields[0] == "max" {
+			stats.Events, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	return stats, nil
 }
@@ -1,9 +1,83 @@
 package subsystems
 
+// ResourceConfig is the typed resource model of a container, every value
+// is parsed and validated before it reaches a cgroup file. A zero value
+// leaves the kernel default in place.
 type ResourceConfig struct {
-	MemoryLimit string
-	CpuShare    string
-	CpuSet      string
+	// MemoryLimit and the other memory sizes are in bytes.
+	MemoryLimit int64 `json:"memoryLimit,omitempty"`
+	// MemorySwap is the limit of memory plus swap, -1 for unlimited swap.
+	MemorySwap        int64 `json:"memorySwap,omitempty"`
+	MemoryReservation int64 `json:"memoryReservation,omitempty"`
+	// MemorySwappiness is in [0, 100], nil keeps the kernel default.
+	MemorySwappiness *uint64 `json:"memorySwappiness,omitempty"`
+	KernelMemory     int64   `json:"kernelMemory,omitempty"`
+	OomKillDisable   bool    `json:"oomKillDisable,omitempty"`
+
+	// CpuShare is the relative cpu weight in [2, 262144].
+	CpuShare uint64 `json:"cpuShare,omitempty"`
+	CpuSet   IdList `json:"cpuSet,omitempty"`
+	// CpuSetMems are the numa nodes the container may allocate memory on.
+	CpuSetMems IdList `json:"cpuSetMems,omitempty"`
+	// PidsLimit of -1 lifts the limit.
+	PidsLimit int64 `json:"pidsLimit,omitempty"`
+
+	// CpuQuota and CpuPeriod are the cfs bandwidth in microseconds, a quota
+	// of -1 lifts the limit.
+	CpuQuota  int64  `json:"cpuQuota,omitempty"`
+	CpuPeriod uint64 `json:"cpuPeriod,omitempty"`
+
+	// BlkioWeight is the relative io weight in [10, 1000].
+	BlkioWeight          uint16         `json:"blkioWeight,omitempty"`
+	BlkioWeightDevice    []*BlkioDevice `json:"blkioWeightDevice,omitempty"`
+	BlkioDeviceReadBps   []*BlkioDevice `json:"blkioDeviceReadBps,omitempty"`
+	BlkioDeviceWriteBps  []*BlkioDevice `json:"blkioDeviceWriteBps,omitempty"`
+	BlkioDeviceReadIOps  []*BlkioDevice `json:"blkioDeviceReadIOps,omitempty"`
+	BlkioDeviceWriteIOps []*BlkioDevice `json:"blkioDeviceWriteIOps,omitempty"`
+
+	HugetlbLimit []*HugetlbLimit `json:"hugetlbLimit,omitempty"`
+
+	// NetClassId tags the packets of the container for the tc cgroup filter.
+	NetClassId       uint32       `json:"netClassId,omitempty"`
+	NetPrioIfpriomap []*IfPrioMap `json:"netPrioIfpriomap,omitempty"`
+
+	// Devices are allowed on top of DefaultAllowedDevices.
+	Devices []DeviceRule `json:"devices,omitempty"`
+}
+
+// Controllers lists the v1 names of the controllers the non-zero values
+// of the config are written to, the devices are left out as every config
+// carries the default allowlist.
+func (r *ResourceConfig) Controllers() []string {
+	var controllers []string
+	if r.MemoryLimit != 0 || r.MemorySwap != 0 || r.MemoryReservation != 0 ||
+		r.MemorySwappiness != nil || r.KernelMemory != 0 || r.OomKillDisable {
+		controllers = append(controllers, "memory")
+	}
+	if len(r.CpuSet) > 0 || len(r.CpuSetMems) > 0 {
+		controllers = append(controllers, "cpuset")
+	}
+	if r.CpuShare != 0 || r.CpuQuota != 0 || r.CpuPeriod != 0 {
+		controllers = append(controllers, "cpu")
+	}
+	if r.PidsLimit != 0 {
+		controllers = append(controllers, "pids")
+	}
+	if r.BlkioWeight != 0 || len(r.BlkioWeightDevice) > 0 ||
+		len(r.BlkioDeviceReadBps) > 0 || len(r.BlkioDeviceWriteBps) > 0 ||
+		len(r.BlkioDeviceReadIOps) > 0 || len(r.BlkioDeviceWriteIOps) > 0 {
+		controllers = append(controllers, "blkio")
+	}
+	if len(r.HugetlbLimit) > 0 {
+		controllers = append(controllers, "hugetlb")
+	}
+	if r.NetClassId != 0 {
+		controllers = append(controllers, "net_cls")
+	}
+	if len(r.NetPrioIfpriomap) > 0 {
+		controllers = append(controllers, "net_prio")
+	}
+	return controllers
 }
 
 type Subsystem interface {
@@ -18,5 +92,14 @@ var (
 		&MemorySubsystem{},
 		&CpusetSubsystem{},
 		&CpuSubsystem{},
+		&PidsSubsystem{},
+		&FreezerSubsystem{},
+		&BlkioSubsystem{},
+		&DevicesSubsystem{},
+		&HugetlbSubsystem{},
+		&CpuacctSubsystem{},
+		&NetClassifierSubsystem{},
+		&NetPrioritySubsystem{},
+		&PerfEventSubsystem{},
 	}
 )
@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 )
 
@@ -13,7 +15,7 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 	cgroupRoot := FindCgroupMountPoint(subsystem)
 	if _, err := os.Stat(path.Join(cgroupRoot, cgroupPath)); err == nil || (autoCreate && os.IsNotExist(err)) {
 		if os.IsNotExist(err) {
-			err := os.Mkdir(path.Join(cgroupRoot, cgroupPath), 0755)
+			err := os.MkdirAll(path.Join(cgroupRoot, cgroupPath), 0755)
 			if err != nil {
 				return "", errors.New(fmt.Sprintf("error create cgroup; %v", err))
 			}
@@ -45,3 +47,76 @@ func FindCgroupMountPoint(subsystem string) string {
 	}
 	return ""
 }
+
+// ReadUint reads a cgroup file holding a single unsigned value, "max" is
+// reported as 0 which means unlimited.
+func ReadUint(file string) (uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// ReadKeyValues reads a flat keyed cgroup file such as memory.stat or cpu.stat,
+// every line is "<key> <value>".
+func ReadKeyValues(file string) (map[string]uint64, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if value, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = value
+		}
+	}
+	return values, nil
+}
+
+// HostMemoryTotal returns MemTotal of /proc/meminfo in bytes.
+func HostMemoryTotal() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// MemTotal:       16303900 kB
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb << 10, nil
+		}
+	}
+	return 0, errors.New("MemTotal not found in /proc/meminfo")
+}
+
+// ReadProcs returns the pids listed in the cgroup.procs of a cgroup directory.
+func ReadProcs(dir string) ([]int, error) {
+	content, err := ioutil.ReadFile(path.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %s in cgroup.procs", field)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
@@ -0,0 +1,106 @@
+package subsystems
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+)
+
+// minMemoryLimit is the smallest hard limit a container can start with,
+// below it the runtime itself is oom killed.
+const minMemoryLimit = 6 << 20
+
+// Validate checks the resources for consistency and against the capacity of
+// the host, online cpus, numa nodes and total memory, before any cgroup is touched.
+func (r *ResourceConfig) Validate() error {
+	if err := r.validateCpu(); err != nil {
+		return err
+	}
+	return r.validateMemory()
+}
+
+func (r *ResourceConfig) validateCpu() error {
+	if len(r.CpuSetMems) > 0 {
+		mems, err := OnlineMems()
+		if err != nil {
+			return fmt.Errorf("read online mems error; %v", err)
+		}
+		if !mems.Contains(r.CpuSetMems) {
+			return fmt.Errorf("cpuset mems %s is not within the online mems %s", r.CpuSetMems, mems)
+		}
+	}
+	if len(r.CpuSet) == 0 && r.CpuQuota <= 0 {
+		return nil
+	}
+	online, err := OnlineCpus()
+	if err != nil {
+		return fmt.Errorf("read online cpus error; %v", err)
+	}
+	if !online.Contains(r.CpuSet) {
+		return fmt.Errorf("cpuset %s is not within the online cpus %s", r.CpuSet, online)
+	}
+	if r.CpuQuota <= 0 {
+		return nil
+	}
+	period := DefaultCpuPeriod
+	if r.CpuPeriod != 0 {
+		period = r.CpuPeriod
+	}
+	requested := float64(r.CpuQuota) / float64(period)
+	if len(r.CpuSet) > 0 && requested > float64(len(r.CpuSet)) {
+		return fmt.Errorf("cpu quota of %.2f cpus exceeds the %d cpus of cpuset %s", requested, len(r.CpuSet), r.CpuSet)
+	}
+	if requested > float64(len(online)) {
+		return fmt.Errorf("cpu quota of %.2f cpus exceeds the %d online cpus", requested, len(online))
+	}
+	return nil
+}
+
+func (r *ResourceConfig) validateMemory() error {
+	total, err := HostMemoryTotal()
+	if err != nil {
+		return fmt.Errorf("read host memory error; %v", err)
+	}
+	limit := r.MemoryLimit
+	if limit < 0 {
+		limit = 0
+	}
+	if limit > 0 {
+		if limit < minMemoryLimit {
+			return fmt.Errorf("memory limit %s is below the minimum of %s", FormatSize(uint64(limit)), FormatSize(minMemoryLimit))
+		}
+		if uint64(limit) > total {
+			return fmt.Errorf("memory limit %s exceeds the host memory %s", FormatSize(uint64(limit)), FormatSize(total))
+		}
+	}
+	if r.MemorySwap > 0 {
+		if limit == 0 {
+			return fmt.Errorf("memory-swap requires a memory limit")
+		}
+		if r.MemorySwap < limit {
+			return fmt.Errorf("memory-swap %s must be at least the memory limit %s, it counts memory plus swap", FormatSize(uint64(r.MemorySwap)), FormatSize(uint64(limit)))
+		}
+	}
+	if r.MemoryReservation > 0 {
+		if limit > 0 && r.MemoryReservation > limit {
+			return fmt.Errorf("memory-reservation %s must not exceed the memory limit %s", FormatSize(uint64(r.MemoryReservation)), FormatSize(uint64(limit)))
+		}
+		if uint64(r.MemoryReservation) > total {
+			return fmt.Errorf("memory-reservation %s exceeds the host memory %s", FormatSize(uint64(r.MemoryReservation)), FormatSize(total))
+		}
+	}
+	if r.MemorySwappiness != nil && *r.MemorySwappiness > 100 {
+		return fmt.Errorf("invalid memory swappiness %d, must be in [0, 100]", *r.MemorySwappiness)
+	}
+	if r.KernelMemory > 0 {
+		if r.KernelMemory < minKernelMemory {
+			return fmt.Errorf("kernel-memory %s is below the minimum of %s", FormatSize(uint64(r.KernelMemory)), FormatSize(minKernelMemory))
+		}
+		if limit > 0 && r.KernelMemory > limit {
+			return fmt.Errorf("kernel-memory %s must not exceed the memory limit %s", FormatSize(uint64(r.KernelMemory)), FormatSize(uint64(limit)))
+		}
+	}
+	if r.OomKillDisable && limit == 0 {
+		logrus.Warnf("oom-kill-disable without a memory limit may hang the host on oom")
+	}
+	return nil
+}
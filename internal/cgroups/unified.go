@@ -0,0 +1,449 @@
+package cgroups
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+// unifiedManager drives a single directory on the cgroup v2 hierarchy, every
+// controller shares it and is configured through its own interface files.
+type unifiedManager struct {
+	root string
+	path string
+}
+
+func newUnifiedManager(root, cgroupPath string) *unifiedManager {
+	return &unifiedManager{
+		root: root,
+		path: path.Join(root, cgroupPath),
+	}
+}
+
+// create makes the cgroup directory and every missing ancestor, delegating
+// all available controllers down the tree so the leaf can use them.
+func (u *unifiedManager) create() error {
+	rel := strings.TrimPrefix(u.path, u.root)
+	current := u.root
+	for _, elem := range strings.Split(strings.Trim(rel, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+		if err := EnableControllers(current); err != nil {
+			logrus.Warnf("%v", err)
+		}
+		current = path.Join(current, elem)
+		if err := os.Mkdir(current, 0755); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("error create cgroup %s; %v", current, err)
+		}
+	}
+	return nil
+}
+
+func (u *unifiedManager) Apply(pid int) error {
+	if err := u.create(); err != nil {
+		return err
+	}
+	if err := writeFile(u.path, "cgroup.procs", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("set cgroup proc fail; %v", err)
+	}
+	return nil
+}
+
+func (u *unifiedManager) Set(res *subsystems.ResourceConfig) error {
+	if err := u.create(); err != nil {
+		return err
+	}
+	if err := u.update(res, nil); err != nil {
+		return err
+	}
+	if res.NetClassId != 0 || len(res.NetPrioIfpriomap) > 0 {
+		// v2 leaves classification to ebpf programs matching the cgroup.
+		logrus.Warnf("net_cls and net_prio are not available on cgroup v2, ignore them")
+	}
+	if os.Geteuid() != 0 {
+		// loading the program needs CAP_SYS_ADMIN or CAP_BPF in the initial
+		// user namespace, a rootless container only reaches the device
+		// nodes the user may open anyway.
+		logrus.Warnf("rootless container runs without device filter")
+	} else if err := setDeviceFilter(u.path, subsystems.AllowedDevices(res)); err != nil {
+		return fmt.Errorf("set cgroup device filter fail; %v", err)
+	}
+	return nil
+}
+
+// Update rewrites the named controllers only, the device filter program is
+// kept as it was attached by Set.
+func (u *unifiedManager) Update(res *subsystems.ResourceConfig, controllers []string) error {
+	return u.update(res, controllers)
+}
+
+// update writes the controllers of res, or only the named ones when
+// controllers is not nil. They are named as on v1 so blkio covers io.
+func (u *unifiedManager) update(res *subsystems.ResourceConfig, controllers []string) error {
+	setters := []struct {
+		controller string
+		set        func(dir string, res *subsystems.ResourceConfig) error
+	}{
+		{"memory", setMemory},
+		{"cpu", setCpu},
+		{"cpuset", setCpuset},
+		{"pids", setPids},
+		{"blkio", setIo},
+		{"hugetlb", setHugetlb},
+	}
+	for _, setter := range setters {
+		if controllers != nil && !containsString(controllers, setter.controller) {
+			continue
+		}
+		if err := setter.set(u.path, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setCpu(dir string, res *subsystems.ResourceConfig) error {
+	if res.CpuShare != 0 {
+		weight := convertCPUSharesToWeight(res.CpuShare)
+		if err := writeFile(dir, "cpu.weight", strconv.FormatUint(weight, 10)); err != nil {
+			return fmt.Errorf("set cgroup cpu weight fail; %v", err)
+		}
+	}
+	if res.CpuQuota != 0 || res.CpuPeriod != 0 {
+		if err := writeFile(dir, "cpu.max", cpuMax(dir, res)); err != nil {
+			return fmt.Errorf("set cgroup cpu max fail; %v", err)
+		}
+	}
+	return nil
+}
+
+func setCpuset(dir string, res *subsystems.ResourceConfig) error {
+	if len(res.CpuSet) == 0 && len(res.CpuSetMems) == 0 {
+		return nil
+	}
+	// v2 cpusets inherit the effective sets of the parent while empty.
+	parent := path.Dir(dir)
+	cpus, err := subsystems.ReadIdList(path.Join(parent, "cpuset.cpus.effective"))
+	if err != nil {
+		return fmt.Errorf("read parent cpuset fail; %v", err)
+	}
+	mems, err := subsystems.ReadIdList(path.Join(parent, "cpuset.mems.effective"))
+	if err != nil {
+		return fmt.Errorf("read parent cpuset fail; %v", err)
+	}
+	if err := subsystems.ValidateCpuset(res, cpus, mems); err != nil {
+		return err
+	}
+	if len(res.CpuSet) > 0 {
+		if err := writeFile(dir, "cpuset.cpus", res.CpuSet.String()); err != nil {
+			return fmt.Errorf("set cgroup cpuset fail; %v", err)
+		}
+	}
+	if len(res.CpuSetMems) > 0 {
+		if err := writeFile(dir, "cpuset.mems", res.CpuSetMems.String()); err != nil {
+			return fmt.Errorf("set cgroup cpuset mems fail; %v", err)
+		}
+	}
+	return nil
+}
+
+func setPids(dir string, res *subsystems.ResourceConfig) error {
+	if res.PidsLimit != 0 {
+		if err := writeFile(dir, "pids.max", subsystems.PidsMax(res.PidsLimit)); err != nil {
+			return fmt.Errorf("set cgroup pids limit fail; %v", err)
+		}
+	}
+	return nil
+}
+
+func setHugetlb(dir string, res *subsystems.ResourceConfig) error {
+	for _, limit := range res.HugetlbLimit {
+		file := fmt.Sprintf("hugetlb.%s.max", limit.PageSize)
+		if err := writeFile(dir, file, strconv.FormatUint(limit.Limit, 10)); err != nil {
+			return fmt.Errorf("set cgroup hugetlb limit fail; %v", err)
+		}
+	}
+	return nil
+}
+
+// setMemory translates the v1 style memory settings, v2 accounts swap on its
+// own so memory.swap.max is the memory-swap total minus the memory limit.
+func setMemory(dir string, res *subsystems.ResourceConfig) error {
+	if res.MemoryLimit != 0 {
+		if err := writeFile(dir, "memory.max", memoryMax(res.MemoryLimit)); err != nil {
+			return fmt.Errorf("set cgroup memory fail; %v", err)
+		}
+	}
+	if res.MemorySwap != 0 {
+		swapMax := "max"
+		if res.MemorySwap > 0 {
+			if res.MemoryLimit <= 0 || res.MemorySwap < res.MemoryLimit {
+				return fmt.Errorf("memory-swap %d must be at least the memory limit %d", res.MemorySwap, res.MemoryLimit)
+			}
+			swapMax = strconv.FormatInt(res.MemorySwap-res.MemoryLimit, 10)
+		}
+		if err := writeFile(dir, "memory.swap.max", swapMax); err != nil {
+			return fmt.Errorf("set cgroup memory swap fail; %v", err)
+		}
+	}
+	if res.MemoryReservation != 0 {
+		if err := writeFile(dir, "memory.low", memoryMax(res.MemoryReservation)); err != nil {
+			return fmt.Errorf("set cgroup memory reservation fail; %v", err)
+		}
+	}
+	if res.MemorySwappiness != nil {
+		logrus.Warnf("memory swappiness is not supported on cgroup v2, ignore it")
+	}
+	if res.KernelMemory != 0 {
+		logrus.Warnf("kernel memory limit is not supported on cgroup v2, ignore it")
+	}
+	if res.OomKillDisable {
+		// the oom killer can not be turned off on v2, at least keep it from
+		// taking down every task of the container at once.
+		logrus.Warnf("oom-kill-disable is not supported on cgroup v2, only memory.oom.group is cleared")
+		if err := writeFile(dir, "memory.oom.group", "0"); err != nil {
+			return fmt.Errorf("set cgroup memory oom group fail; %v", err)
+		}
+	}
+	return nil
+}
+
+// setIo translates the blkio settings into io.weight and io.max, all
+// throttles of one device share a single io.max line.
+func setIo(dir string, res *subsystems.ResourceConfig) error {
+	if res.BlkioWeight != 0 {
+		if err := writeFile(dir, "io.weight", fmt.Sprintf("default %d", convertBlkioToIOWeight(uint64(res.BlkioWeight)))); err != nil {
+			return fmt.Errorf("set cgroup io weight fail; %v", err)
+		}
+	}
+	for _, device := range res.BlkioWeightDevice {
+		if err := writeFile(dir, "io.weight", fmt.Sprintf("%s %d", device.Key(), convertBlkioToIOWeight(device.Value))); err != nil {
+			return fmt.Errorf("set cgroup io weight fail; %v", err)
+		}
+	}
+
+	var keys []string
+	limits := map[string][]string{}
+	throttles := []struct {
+		key     string
+		devices []*subsystems.BlkioDevice
+	}{
+		{"rbps", res.BlkioDeviceReadBps},
+		{"wbps", res.BlkioDeviceWriteBps},
+		{"riops", res.BlkioDeviceReadIOps},
+		{"wiops", res.BlkioDeviceWriteIOps},
+	}
+	for _, throttle := range throttles {
+		for _, device := range throttle.devices {
+			if _, ok := limits[device.Key()]; !ok {
+				keys = append(keys, device.Key())
+			}
+			limits[device.Key()] = append(limits[device.Key()], fmt.Sprintf("%s=%d", throttle.key, device.Value))
+		}
+	}
+	for _, key := range keys {
+		line := key + " " + strings.Join(limits[key], " ")
+		if err := writeFile(dir, "io.max", line); err != nil {
+			return fmt.Errorf("set cgroup io max fail; %v", err)
+		}
+	}
+	return nil
+}
+
+// Destroy removes the cgroup together with the child cgroups a delegated
+// container created, deepest first as rmdir only takes empty cgroups.
+func (u *unifiedManager) Destroy() error {
+	if err := removeCgroupTree(u.path); err != nil && !os.IsNotExist(err) {
+		logrus.Errorf("remove cgroup %s fail; err %v", u.path, err)
+		return err
+	}
+	return nil
+}
+
+func removeCgroupTree(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if err := removeCgroupTree(path.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Remove(dir)
+}
+
+// Freeze writes cgroup.freeze and waits for the "frozen" key of cgroup.events
+// to follow, the kernel flips it once every task has stopped or resumed.
+func (u *unifiedManager) Freeze(state subsystems.FreezerState) error {
+	var value string
+	switch state {
+	case subsystems.Frozen:
+		value = "1"
+	case subsystems.Thawed:
+		value = "0"
+	default:
+		return fmt.Errorf("invalid freezer state %s", state)
+	}
+	if err := writeFile(u.path, "cgroup.freeze", value); err != nil {
+		return fmt.Errorf("set cgroup freeze fail; %v", err)
+	}
+	for i := 0; i < subsystems.FreezerRetries; i++ {
+		content, err := ioutil.ReadFile(path.Join(u.path, "cgroup.events"))
+		if err != nil {
+			return fmt.Errorf("read cgroup events fail; %v", err)
+		}
+		for _, line := range strings.Split(string(content), "\n") {
+			if line == "frozen "+value {
+				return nil
+			}
+		}
+		time.Sleep(subsystems.FreezerRetryInterval)
+	}
+	return fmt.Errorf("cgroup %s did not reach freezer state %s", u.path, state)
+}
+
+// GetPids also lists the processes of the child cgroups, a delegated
+// container keeps none in its own cgroup.
+func (u *unifiedManager) GetPids() ([]int, error) {
+	var pids []int
+	err := filepath.Walk(u.path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		procs, err := subsystems.ReadProcs(file)
+		if err != nil {
+			return err
+		}
+		pids = append(pids, procs...)
+		return nil
+	})
+	return pids, err
+}
+
+func (u *unifiedManager) GetStats() (*Stats, error) {
+	if _, err := os.Stat(u.path); err != nil {
+		return nil, fmt.Errorf("cgroup path error; %v", err)
+	}
+	stats := &Stats{}
+	if cpuStat, err := subsystems.ReadKeyValues(path.Join(u.path, "cpu.stat")); err == nil {
+		stats.Cpu = subsystems.CpuacctStats{
+			Usage:  cpuStat["usage_usec"] * 1000,
+			User:   cpuStat["user_usec"] * 1000,
+			System: cpuStat["system_usec"] * 1000,
+		}
+	} else {
+		logrus.Warnf("read cgroup %s cpu.stat fail; %v", u.path, err)
+	}
+
+	if usage, err := subsystems.ReadUint(path.Join(u.path, "memory.current")); err == nil {
+		if memoryStat, err := subsystems.ReadKeyValues(path.Join(u.path, "memory.stat")); err == nil {
+			if inactive := memoryStat["inactive_file"]; inactive < usage {
+				usage -= inactive
+			}
+		}
+		stats.Memory.Usage = usage
+		stats.Memory.Limit, _ = subsystems.ReadUint(path.Join(u.path, "memory.max"))
+	} else {
+		logrus.Warnf("read cgroup %s memory.current fail; %v", u.path, err)
+	}
+
+	if pidsStats, err := subsystems.ReadPidsStats(u.path); err == nil {
+		stats.Pids = *pidsStats
+	} else {
+		logrus.Warnf("read cgroup %s pids stats fail; %v", u.path, err)
+	}
+
+	// io.stat lines look like "8:0 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0".
+	if content, err := ioutil.ReadFile(path.Join(u.path, "io.stat")); err == nil {
+		for _, field := range strings.Fields(string(content)) {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value, _ := strconv.ParseUint(kv[1], 10, 64)
+			switch kv[0] {
+			case "rbytes":
+				stats.Blkio.Read += value
+			case "wbytes":
+				stats.Blkio.Write += value
+			}
+		}
+	} else {
+		logrus.Warnf("read cgroup %s io.stat fail; %v", u.path, err)
+	}
+
+	if pressure, err := u.GetPressure(); err == nil {
+		stats.Pressure = pressure
+	} else {
+		logrus.Warnf("read cgroup %s pressure fail; %v", u.path, err)
+	}
+	return stats, nil
+}
+
+// cpuMax formats "<quota> [<period>]" for cpu.max, a quota alone keeps the
+// current period and a period alone keeps the current quota.
+func cpuMax(dir string, res *subsystems.ResourceConfig) string {
+	var quota string
+	if res.CpuQuota < 0 {
+		quota = "max"
+	} else if res.CpuQuota > 0 {
+		quota = strconv.FormatInt(res.CpuQuota, 10)
+	}
+	if res.CpuPeriod == 0 {
+		return quota
+	}
+	if quota == "" {
+		quota = "max"
+		if content, err := ioutil.ReadFile(path.Join(dir, "cpu.max")); err == nil {
+			if fields := strings.Fields(string(content)); len(fields) == 2 {
+				quota = fields[0]
+			}
+		}
+	}
+	return quota + " " + strconv.FormatUint(res.CpuPeriod, 10)
+}
+
+// memoryMax formats a memory size for the v2 files, -1 is "max".
+func memoryMax(size int64) string {
+	if size < 0 {
+		return "max"
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// convertCPUSharesToWeight maps cpu.shares [2, 262144] onto cpu.weight [1, 10000].
+func convertCPUSharesToWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// convertBlkioToIOWeight maps blkio.weight [10, 1000] onto io.weight [1, 10000].
+func convertBlkioToIOWeight(weight uint64) uint64 {
+	return 1 + (weight-10)*9999/990
+}
+
+func writeFile(dir, file, data string) error {
+	return ioutil.WriteFile(path.Join(dir, file), []byte(data), 0644)
+}
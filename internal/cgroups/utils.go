@@ -0,0 +1,113 @@
+package cgroups
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// CgroupMode describes how the cgroup hierarchies are laid out on the host.
+type CgroupMode int
+
+const (
+	// Legacy means only cgroup v1 hierarchies are mounted.
+	Legacy CgroupMode = iota
+	// Hybrid means the controllers live on cgroup v1 hierarchies while an
+	// empty cgroup v2 hierarchy is mounted alongside them (e.g. /sys/fs/cgroup/unified).
+	Hybrid
+	// Unified means every controller lives on a single cgroup v2 hierarchy.
+	Unified
+)
+
+func (m CgroupMode) String() string {
+	switch m {
+	case Legacy:
+		return "legacy"
+	case Hybrid:
+		return "hybrid"
+	case Unified:
+		return "unified"
+	}
+	return "unknown"
+}
+
+// Mount is a cgroup hierarchy mounted on the host, Options are the super
+// options of the hierarchy such as "rw,cpu,cpuacct".
+type Mount struct {
+	Mountpoint string
+	Fstype     string
+	Options    string
+}
+
+// GetCgroupMounts lists the cgroup and cgroup2 mounts of /proc/self/mountinfo.
+func GetCgroupMounts() ([]Mount, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mounts []Mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+		txt := scanner.Text()
+		parts := strings.SplitN(txt, " - ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		fields := strings.Split(parts[0], " ")
+		postFields := strings.Split(parts[1], " ")
+		if len(fields) < 5 || len(postFields) < 3 {
+			continue
+		}
+		if postFields[0] != "cgroup" && postFields[0] != "cgroup2" {
+			continue
+		}
+		mounts = append(mounts, Mount{
+			Mountpoint: fields[4],
+			Fstype:     postFields[0],
+			Options:    postFields[2],
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// GetCgroupMode inspects /proc/self/mountinfo and reports which cgroup
+// layout is in use, together with the cgroup2 mount point if there is one.
+func GetCgroupMode() (CgroupMode, string) {
+	mounts, err := GetCgroupMounts()
+	if err != nil {
+		return Legacy, ""
+	}
+
+	legacyMounted := false
+	unifiedMountPoint := ""
+	for _, mount := range mounts {
+		switch mount.Fstype {
+		case "cgroup":
+			legacyMounted = true
+		case "cgroup2":
+			if unifiedMountPoint == "" {
+				unifiedMountPoint = mount.Mountpoint
+			}
+		}
+	}
+
+	switch {
+	case unifiedMountPoint != "" && !legacyMounted:
+		return Unified, unifiedMountPoint
+	case unifiedMountPoint != "":
+		return Hybrid, unifiedMountPoint
+	}
+	return Legacy, ""
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -8,12 +8,20 @@ func init() {
 	Commands = append(
 		Commands,
 		initCommand,
+		oomWatchCommand,
 		runCommand,
 		commitCommand,
 		listCommand,
+		statsCommand,
+		pressureCommand,
+		perfCommand,
+		topCommand,
+		updateCommand,
 		logCommand,
 		execCommand,
 		stopCommand,
+		pauseCommand,
+		unpauseCommand,
 		removeCommand,
 		networkCommand,
 	)
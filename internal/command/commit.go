@@ -2,7 +2,6 @@ package command
 
 import (
 	"errors"
-	"fmt"
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os/exec"
@@ -26,8 +25,7 @@ var commitCommand = cli.Command{
 }
 
 func commitContainer(containerName, imageName string) {
-	mntURL := fmt.Sprintf(container.MntUrl, containerName)
-	mntURL += "/"
+	mntURL := container.MountPoint(containerName) + "/"
 
 	imageTar := container.RootUrl + "/" + imageName + ".tar"
 	logrus.Infof("%s", imageTar)
@@ -12,6 +12,12 @@ import (
 var execCommand = cli.Command{
 	Name:  "exec",
 	Usage: "exec a command into container",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "security options, no-new-privileges",
+		},
+	},
 	Action: func(context *cli.Context) error {
 		if os.Getenv(container.ENV_EXEC_PID) != "" {
 			logrus.Infof("pid callback pid %v", os.Getgid())
@@ -25,7 +31,11 @@ var execCommand = cli.Command{
 		for _, arg := range context.Args().Tail() {
 			commandArray = append(commandArray, arg)
 		}
-		container.ExecContainer(containerName, commandArray)
+		security, err := container.ParseExecSecurityOpts(context.StringSlice("security-opt"))
+		if err != nil {
+			return err
+		}
+		container.ExecContainer(containerName, commandArray, security)
 		return nil
 	},
 }
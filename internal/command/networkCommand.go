@@ -22,13 +22,17 @@ var networkCommand = cli.Command{
 					Name:  "subnet",
 					Usage: "subnet cidr",
 				},
+				cli.BoolFlag{
+					Name:  "tc-cgroup-filter",
+					Usage: "bridge only, classify the egress of every container by its --net-classid under a htb qdisc 10:",
+				},
 			},
 			Action: func(context *cli.Context) error {
 				if len(context.Args()) < 1 {
 					return fmt.Errorf("missing network name")
 				}
 				network.Init()
-				err := network.CreateNetwork(context.String("driver"), context.String("subnet"), context.Args()[0])
+				err := network.CreateNetwork(context.String("driver"), context.String("subnet"), context.Args()[0], context.Bool("tc-cgroup-filter"))
 				if err != nil {
 					return fmt.Errorf("create network error; %v", err)
 				}
@@ -0,0 +1,19 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"toy-runc/internal/container"
+)
+
+var oomWatchCommand = cli.Command{
+	Name:  "oom-watch",
+	Usage: "Watch a container for oom kills and record them. Do not call it outside",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		container.WatchContainerOOM(context.Args().Get(0))
+		return nil
+	},
+}
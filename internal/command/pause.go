@@ -0,0 +1,20 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"toy-runc/internal/container"
+)
+
+var pauseCommand = cli.Command{
+	Name:  "pause",
+	Usage: "suspend all processes of a container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		container.PauseContainer(containerName)
+		return nil
+	},
+}
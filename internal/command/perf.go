@@ -0,0 +1,47 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"strings"
+	"toy-runc/internal/cgroups"
+	"toy-runc/internal/container"
+)
+
+var perfCommand = cli.Command{
+	Name:  "perf",
+	Usage: "count perf events of a container, until interrupted or for a duration",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "events, e",
+			Usage: "comma separated events, e.g. task-clock,context-switches,page-faults",
+			Value: strings.Join(cgroups.DefaultPerfEvents, ","),
+		},
+		cli.DurationFlag{
+			Name:  "duration",
+			Usage: "how long to count, until interrupted by default",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the counts as json",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		if context.Duration("duration") < 0 {
+			return fmt.Errorf("duration must not be negative")
+		}
+		var events []string
+		for _, event := range strings.Split(context.String("events"), ",") {
+			if event = strings.TrimSpace(event); event != "" {
+				events = append(events, event)
+			}
+		}
+		if len(events) == 0 {
+			return fmt.Errorf("missing perf events")
+		}
+		return container.PerfContainer(context.Args().Get(0), events, context.Duration("duration"), context.Bool("json"))
+	},
+}
@@ -0,0 +1,37 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"toy-runc/internal/cgroups"
+	"toy-runc/internal/container"
+)
+
+var pressureCommand = cli.Command{
+	Name:  "pressure",
+	Usage: "display the cpu, memory and io pressure stall information of a container, cgroup v2 only",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "trigger",
+			Usage: "keep watching and log when a stall exceeds <resource>:<some|full>:<threshold>/<window>, e.g. memory:some:150ms/1s",
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print the pressure as json",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		var triggers []*cgroups.PressureTrigger
+		for _, spec := range context.StringSlice("trigger") {
+			trigger, err := cgroups.ParsePressureTrigger(spec)
+			if err != nil {
+				return err
+			}
+			triggers = append(triggers, trigger)
+		}
+		return container.PressureContainer(context.Args().Get(0), triggers, context.Bool("json"))
+	},
+}
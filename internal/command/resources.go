@@ -0,0 +1,166 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"strconv"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+// parseResourceFlags parses the resource flags given on the command line
+// into res, flags that are not set keep the value res already has so the
+// same parser serves run and update.
+func parseResourceFlags(context *cli.Context, res *subsystems.ResourceConfig) error {
+	memoryFlags := []struct {
+		name  string
+		value *int64
+	}{
+		{"m", &res.MemoryLimit},
+		{"memory", &res.MemoryLimit},
+		{"memory-swap", &res.MemorySwap},
+		{"memory-reservation", &res.MemoryReservation},
+		{"kernel-memory", &res.KernelMemory},
+	}
+	for _, flag := range memoryFlags {
+		if value := context.String(flag.name); value != "" {
+			size, err := subsystems.ParseMemory(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s; %v", flag.name, err)
+			}
+			*flag.value = size
+		}
+	}
+	if value := context.String("memory-swappiness"); value != "" {
+		swappiness, err := subsystems.ParseSwappiness(value)
+		if err != nil {
+			return err
+		}
+		res.MemorySwappiness = swappiness
+	}
+	if context.Bool("oom-kill-disable") {
+		res.OomKillDisable = true
+	}
+
+	if value := context.String("cpushare"); value != "" {
+		shares, err := subsystems.ParseCpuShares(value)
+		if err != nil {
+			return err
+		}
+		res.CpuShare = shares
+	}
+	if value := context.String("cpuset"); value != "" {
+		cpus, err := subsystems.ParseIdList(value)
+		if err != nil {
+			return fmt.Errorf("invalid cpuset; %v", err)
+		}
+		res.CpuSet = cpus
+	}
+	if value := context.String("cpuset-mems"); value != "" {
+		mems, err := subsystems.ParseIdList(value)
+		if err != nil {
+			return fmt.Errorf("invalid cpuset mems; %v", err)
+		}
+		res.CpuSetMems = mems
+	}
+	if value := context.String("numa-node"); value != "" {
+		if context.String("cpuset") != "" || context.String("cpuset-mems") != "" {
+			return fmt.Errorf("numa-node can not be used together with cpuset or cpuset-mems")
+		}
+		node, err := strconv.Atoi(value)
+		if err != nil || node < 0 {
+			return fmt.Errorf("invalid numa node %s", value)
+		}
+		cpus, err := subsystems.NumaNodeCpus(node)
+		if err != nil {
+			return err
+		}
+		res.CpuSet = cpus
+		res.CpuSetMems = subsystems.IdList{node}
+	}
+	if value := context.String("cpu-period"); value != "" {
+		period, err := subsystems.ParseCpuPeriod(value)
+		if err != nil {
+			return err
+		}
+		res.CpuPeriod = period
+	}
+	if value := context.String("cpu-quota"); value != "" {
+		quota, err := subsystems.ParseCpuQuota(value)
+		if err != nil {
+			return err
+		}
+		res.CpuQuota = quota
+	}
+	if value := context.String("cpus"); value != "" {
+		if context.String("cpu-quota") != "" {
+			return fmt.Errorf("cpus and cpu-quota can not both provided")
+		}
+		period := subsystems.DefaultCpuPeriod
+		if res.CpuPeriod != 0 {
+			period = res.CpuPeriod
+		}
+		quota, err := subsystems.ParseCpus(value, period)
+		if err != nil {
+			return err
+		}
+		res.CpuQuota = quota
+		res.CpuPeriod = period
+	}
+	if value := context.String("pids-limit"); value != "" {
+		limit, err := subsystems.ParsePidsLimit(value)
+		if err != nil {
+			return err
+		}
+		res.PidsLimit = limit
+	}
+
+	if value := context.String("blkio-weight"); value != "" {
+		weight, err := subsystems.ParseBlkioWeight(value)
+		if err != nil {
+			return err
+		}
+		res.BlkioWeight = uint16(weight)
+	}
+	deviceFlags := []struct {
+		name    string
+		parse   func(string) (*subsystems.BlkioDevice, error)
+		devices *[]*subsystems.BlkioDevice
+	}{
+		{"blkio-weight-device", subsystems.ParseWeightDevice, &res.BlkioWeightDevice},
+		{"device-read-bps", subsystems.ParseRateDevice, &res.BlkioDeviceReadBps},
+		{"device-write-bps", subsystems.ParseRateDevice, &res.BlkioDeviceWriteBps},
+		{"device-read-iops", subsystems.ParseIOpsDevice, &res.BlkioDeviceReadIOps},
+		{"device-write-iops", subsystems.ParseIOpsDevice, &res.BlkioDeviceWriteIOps},
+	}
+	for _, flag := range deviceFlags {
+		for _, spec := range context.StringSlice(flag.name) {
+			device, err := flag.parse(spec)
+			if err != nil {
+				return err
+			}
+			*flag.devices = append(*flag.devices, device)
+		}
+	}
+	if value := context.String("net-classid"); value != "" {
+		classId, err := subsystems.ParseNetClassId(value)
+		if err != nil {
+			return err
+		}
+		res.NetClassId = classId
+	}
+	for _, spec := range context.StringSlice("net-prio") {
+		prioMap, err := subsystems.ParseIfPrioMap(spec)
+		if err != nil {
+			return err
+		}
+		res.NetPrioIfpriomap = append(res.NetPrioIfpriomap, prioMap)
+	}
+	for _, spec := range context.StringSlice("hugetlb") {
+		limit, err := subsystems.ParseHugetlbLimit(spec)
+		if err != nil {
+			return err
+		}
+		res.HugetlbLimit = append(res.HugetlbLimit, limit)
+	}
+	return nil
+}
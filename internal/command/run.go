@@ -6,6 +6,7 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"toy-runc/internal/cgroups"
@@ -30,6 +31,26 @@ var runCommand = cli.Command{
 			Name:  "m",
 			Usage: "memory limit",
 		},
+		cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "memory plus swap limit, -1 for unlimited swap",
+		},
+		cli.StringFlag{
+			Name:  "memory-reservation",
+			Usage: "memory soft limit",
+		},
+		cli.StringFlag{
+			Name:  "memory-swappiness",
+			Usage: "memory swappiness between 0 and 100",
+		},
+		cli.StringFlag{
+			Name:  "kernel-memory",
+			Usage: "kernel memory limit, cgroup v1 on kernels before 5.16 only",
+		},
+		cli.BoolFlag{
+			Name:  "oom-kill-disable",
+			Usage: "disable the oom killer for the container",
+		},
 		cli.StringFlag{
 			Name:  "cpushare",
 			Usage: "cpushare limit",
@@ -38,6 +59,70 @@ var runCommand = cli.Command{
 			Name:  "cpuset",
 			Usage: "cpuset limit",
 		},
+		cli.StringFlag{
+			Name:  "cpuset-mems",
+			Usage: "numa nodes to allocate memory on, e.g. 0-1",
+		},
+		cli.StringFlag{
+			Name:  "numa-node",
+			Usage: "pin cpus and memory to one numa node",
+		},
+		cli.StringFlag{
+			Name:  "cpu-quota",
+			Usage: "cfs quota in microseconds per period, -1 for unlimited",
+		},
+		cli.StringFlag{
+			Name:  "cpu-period",
+			Usage: "cfs period in microseconds, default 100000",
+		},
+		cli.StringFlag{
+			Name:  "cpus",
+			Usage: "number of cpus, e.g. 1.5, shortcut for cpu-quota",
+		},
+		cli.StringFlag{
+			Name:  "pids-limit",
+			Usage: "max number of processes in the container, 0 or -1 for unlimited",
+		},
+		cli.StringFlag{
+			Name:  "blkio-weight",
+			Usage: "block io relative weight, between 10 and 1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "blkio-weight-device",
+			Usage: "block io weight of a device, e.g. /dev/sda:200",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-bps",
+			Usage: "limit read rate from a device, e.g. /dev/sda:10mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-bps",
+			Usage: "limit write rate to a device, e.g. /dev/sda:10mb",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-read-iops",
+			Usage: "limit read io per second from a device, e.g. /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "device-write-iops",
+			Usage: "limit write io per second to a device, e.g. /dev/sda:1000",
+		},
+		cli.StringSliceFlag{
+			Name:  "hugetlb",
+			Usage: "huge page limit, <page size>:<limit> e.g. 2MB:512m",
+		},
+		cli.StringFlag{
+			Name:  "net-classid",
+			Usage: "tc class of the packets sent by the container, <major>:<minor> in hex e.g. 10:1",
+		},
+		cli.StringSliceFlag{
+			Name:  "net-prio",
+			Usage: "priority of the packets sent through a host interface, e.g. eth0:5",
+		},
+		cli.StringSliceFlag{
+			Name:  "device",
+			Usage: "pass a host device to the container, /dev/xyz[:/dev/abc][:rwm]",
+		},
 		cli.StringFlag{
 			Name:  "name",
 			Usage: "container name",
@@ -58,6 +143,42 @@ var runCommand = cli.Command{
 			Name:  "p",
 			Usage: "port mapping",
 		},
+		cli.StringFlag{
+			Name:  "cgroup-parent",
+			Usage: "parent cgroup of the container, default toy-runc",
+		},
+		cli.StringFlag{
+			Name:  "cgroupns",
+			Usage: "cgroup namespace, private or host, default private on cgroup v2 and host otherwise",
+		},
+		cli.StringFlag{
+			Name:  "userns-remap",
+			Usage: "run in a user namespace mapped to the subordinate ids of user[:group] in /etc/subuid and /etc/subgid",
+		},
+		cli.StringSliceFlag{
+			Name:  "uidmap",
+			Usage: "uid mapping of a user namespace, <container uid>:<host uid>:<size> e.g. 0:100000:65536",
+		},
+		cli.StringSliceFlag{
+			Name:  "gidmap",
+			Usage: "gid mapping of a user namespace, <container gid>:<host gid>:<size>, default the uid mapping",
+		},
+		cli.BoolFlag{
+			Name:  "delegate-cgroup",
+			Usage: "let the container create and manage child cgroups below its own, cgroup v2 only",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-add",
+			Usage: "add a capability to the default set, ALL for every capability",
+		},
+		cli.StringSliceFlag{
+			Name:  "cap-drop",
+			Usage: "drop a capability from the default set, ALL for every capability",
+		},
+		cli.StringSliceFlag{
+			Name:  "security-opt",
+			Usage: "security options, no-new-privileges, seccomp=<profile.json> or seccomp=unconfined, default the built-in seccomp profile",
+		},
 	},
 
 	Action: func(context *cli.Context) error {
@@ -78,45 +199,163 @@ var runCommand = cli.Command{
 			return fmt.Errorf("it and d paramter can not both provided")
 		}
 
+		var devices []*container.Device
+		for _, spec := range context.StringSlice("device") {
+			device, err := container.ParseDevice(spec)
+			if err != nil {
+				return err
+			}
+			devices = append(devices, device)
+		}
+
 		resConf := &subsystems.ResourceConfig{
-			MemoryLimit: context.String("m"),
-			CpuShare:    context.String("cpushare"),
-			CpuSet:      context.String("cpuset"),
+			Devices: container.DeviceRules(devices),
 		}
+		if err := parseResourceFlags(context, resConf); err != nil {
+			return err
+		}
+		if err := resConf.Validate(); err != nil {
+			return err
+		}
+
 		containerName := context.String("name")
 		volume := context.String("v")
 		network := context.String("net")
 		portMapping := context.StringSlice("p")
 		envSlice := context.StringSlice("e")
+		cgroupParent := context.String("cgroup-parent")
+		cgroupns, err := container.ParseCgroupns(context.String("cgroupns"))
+		if err != nil {
+			return err
+		}
 
-		run(tty, cmdArray, resConf, containerName, volume, imageName, envSlice, network, portMapping)
+		delegateCgroup := context.Bool("delegate-cgroup")
+		if delegateCgroup {
+			if err := container.CheckDelegateCgroup(cgroupns); err != nil {
+				return err
+			}
+		}
+
+		idMappings, err := parseIDMappings(context)
+		if err != nil {
+			return err
+		}
+		if container.Rootless {
+			if idMappings != nil {
+				return fmt.Errorf("userns-remap, uidmap and gidmap need root, a rootless container maps the user to root")
+			}
+			idMappings = container.RootlessIDMappings()
+			if network != "" || len(portMapping) > 0 {
+				logrus.Warnf("rootless container runs without network, net and p are ignored")
+				network, portMapping = "", nil
+			}
+		}
+		if idMappings != nil && !container.Rootless {
+			if err := container.SetupRemapRoot(idMappings); err != nil {
+				return err
+			}
+		}
+		if idMappings != nil && cgroupns == container.CgroupnsPrivate {
+			// a user namespace may only mount cgroup2, v1 hierarchies are refused.
+			if mode, _ := cgroups.GetCgroupMode(); mode != cgroups.Unified {
+				return fmt.Errorf("a user namespace needs cgroupns %s on a %s host", container.CgroupnsHost, mode)
+			}
+		}
+
+		caps, err := container.ParseCapabilities(context.StringSlice("cap-add"), context.StringSlice("cap-drop"))
+		if err != nil {
+			return err
+		}
+		security, err := container.ParseSecurityOpts(context.StringSlice("security-opt"))
+		if err != nil {
+			return err
+		}
+
+		run(tty, cmdArray, resConf, containerName, volume, imageName, envSlice, network, portMapping, cgroupParent, devices, cgroupns,
+			delegateCgroup, idMappings, caps, security)
 		return nil
 	},
 }
 
 func run(tty bool, cmdArray []string, res *subsystems.ResourceConfig, containerName, volume, imageName string, envSlice []string,
-	nw string, portMapping []string) {
+	nw string, portMapping []string, cgroupParent string, devices []*container.Device, cgroupns string,
+	delegateCgroup bool, idMappings *container.IDMappings, caps []string, security *container.SecurityConfig) {
 	containerID := container.RandStringBytes(10)
 
 	if containerName == "" {
 		containerName = containerID
 	}
+	var cgroupPath string
+	switch {
+	case cgroupParent != "":
+		cgroupPath = path.Join(cgroupParent, containerID)
+	case container.Rootless:
+		// without root the container only gets a cgroup below a delegated one.
+		if parent, err := cgroups.RootlessCgroupParent(container.DefaultCgroupParent); err == nil {
+			cgroupPath = path.Join(parent, containerID)
+		} else {
+			logrus.Warnf("rootless container runs without cgroup, resource limits are ignored; %v", err)
+		}
+	default:
+		cgroupPath = path.Join(container.DefaultCgroupParent, containerID)
+	}
+	if cgroupPath == "" && delegateCgroup {
+		logrus.Errorf("delegate-cgroup needs a cgroup")
+		return
+	}
+
+	// the limits are written before the container starts, a limit the
+	// parent cgroup can not grant fails the run instead of being dropped.
+	// the cgroup outlives this process for detached containers, it is
+	// destroyed together with the container by `rm`.
+	var cgroupManager *cgroups.CgroupManager
+	if cgroupPath != "" {
+		cgroupManager = cgroups.NewCgroupManager(cgroupPath)
+		if err := cgroupManager.Set(res); err != nil {
+			logrus.Errorf("set cgroup resource error; %v", err)
+			cgroupManager.Destroy()
+			return
+		}
+	}
 
-	parent, writePipe := container.NewParentProcess(tty, containerName, volume, imageName, envSlice)
+	parent, writePipe := container.NewParentProcess(tty, containerName, volume, imageName, envSlice, devices, cgroupns, delegateCgroup, idMappings,
+		caps, security)
+	if parent == nil {
+		if cgroupManager != nil {
+			cgroupManager.Destroy()
+		}
+		return
+	}
 	if err := parent.Start(); err != nil {
-		logrus.Error(err)
+		logrus.Errorf("start container process error; %v", err)
+		if cgroupManager != nil {
+			cgroupManager.Destroy()
+		}
+		container.DeleteWorkSpace(volume, containerName)
+		return
 	}
 
-	containerName, err := container.RecordContainerInfo(parent.Process.Pid, cmdArray, containerName, containerID, volume)
+	containerName, err := container.RecordContainerInfo(parent.Process.Pid, cmdArray, containerName, containerID, volume, cgroupPath, res, cgroupns, delegateCgroup, idMappings, caps,
+		security)
 	if err != nil {
 		logrus.Errorf("record container info error; %v", err)
 		return
 	}
 
-	cgroupManager := cgroups.NewCgroupManager("toyRunC-cgroup")
-	defer cgroupManager.Destroy()
-	cgroupManager.Set(res)
-	cgroupManager.Apply(parent.Process.Pid)
+	if cgroupManager != nil {
+		if err := cgroupManager.Apply(parent.Process.Pid); err != nil {
+			logrus.Errorf("apply cgroup error; %v", err)
+		}
+	}
+	if delegateCgroup {
+		uid, gid := 0, 0
+		if idMappings != nil {
+			uid, gid = idMappings.RootUid(), idMappings.RootGid()
+		}
+		if err := cgroupManager.Delegate(uid, gid); err != nil {
+			logrus.Errorf("delegate cgroup error; %v", err)
+		}
+	}
 
 	if nw != "" {
 		network.Init()
@@ -133,13 +372,58 @@ func run(tty bool, cmdArray []string, res *subsystems.ResourceConfig, containerN
 	}
 	sendInitCommand(cmdArray, writePipe)
 
+	if tty {
+		go container.WatchContainerOOM(containerName)
+	} else if err := container.StartOOMWatcher(containerName); err != nil {
+		logrus.Errorf("start oom watcher error; %v", err)
+	}
+
 	if tty {
 		parent.Wait()
+		if cgroupManager != nil {
+			cgroupManager.Destroy()
+		}
 		container.DeleteContainerInfo(containerName)
 		container.DeleteWorkSpace(volume, containerName)
 	}
 }
 
+// parseIDMappings reads --userns-remap or --uidmap and --gidmap, nil means
+// the container shares the user namespace of the host.
+func parseIDMappings(context *cli.Context) (*container.IDMappings, error) {
+	remap := context.String("userns-remap")
+	uidSpecs := context.StringSlice("uidmap")
+	gidSpecs := context.StringSlice("gidmap")
+	if remap != "" {
+		if len(uidSpecs) > 0 || len(gidSpecs) > 0 {
+			return nil, fmt.Errorf("userns-remap can not be used together with uidmap or gidmap")
+		}
+		return container.RemapIDMappings(remap)
+	}
+	if len(uidSpecs) == 0 {
+		if len(gidSpecs) > 0 {
+			return nil, fmt.Errorf("gidmap needs uidmap")
+		}
+		return nil, nil
+	}
+	var uidMaps, gidMaps []container.IDMap
+	for _, spec := range uidSpecs {
+		m, err := container.ParseIDMap(spec)
+		if err != nil {
+			return nil, err
+		}
+		uidMaps = append(uidMaps, m)
+	}
+	for _, spec := range gidSpecs {
+		m, err := container.ParseIDMap(spec)
+		if err != nil {
+			return nil, err
+		}
+		gidMaps = append(gidMaps, m)
+	}
+	return container.NewIDMappings(uidMaps, gidMaps)
+}
+
 func sendInitCommand(cmdArray []string, writePipe *os.File) {
 	defer writePipe.Close()
 	command := strings.Join(cmdArray, " ")
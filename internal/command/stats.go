@@ -0,0 +1,39 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"time"
+	"toy-runc/internal/container"
+)
+
+var statsCommand = cli.Command{
+	Name:  "stats",
+	Usage: "display live resource usage of containers, all running ones by default",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "no-stream",
+			Usage: "print a single sample and exit",
+		},
+		cli.DurationFlag{
+			Name:  "interval",
+			Usage: "refresh interval",
+			Value: time.Second,
+		},
+		cli.BoolFlag{
+			Name:  "json",
+			Usage: "print one json object per container",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if context.Duration("interval") <= 0 {
+			return fmt.Errorf("interval must be positive")
+		}
+		var containerNames []string
+		for _, arg := range context.Args() {
+			containerNames = append(containerNames, arg)
+		}
+		container.StatsContainer(containerNames, !context.Bool("no-stream"), context.Duration("interval"), context.Bool("json"))
+		return nil
+	},
+}
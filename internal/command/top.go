@@ -0,0 +1,21 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"toy-runc/internal/container"
+)
+
+var topCommand = cli.Command{
+	Name:      "top",
+	Usage:     "display the processes of a container, with their pid on the host and in the container",
+	ArgsUsage: "<container> [ps options]",
+	// ps options such as -o pid,comm must reach ps untouched.
+	SkipFlagParsing: true,
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		return container.TopContainer(context.Args().Get(0), context.Args().Tail())
+	},
+}
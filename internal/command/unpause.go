@@ -0,0 +1,20 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"toy-runc/internal/container"
+)
+
+var unpauseCommand = cli.Command{
+	Name:  "unpause",
+	Usage: "resume all processes of a paused container",
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		container.UnpauseContainer(containerName)
+		return nil
+	},
+}
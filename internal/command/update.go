@@ -0,0 +1,56 @@
+package command
+
+import (
+	"fmt"
+	"github.com/urfave/cli"
+	"toy-runc/internal/cgroups/subsystems"
+	"toy-runc/internal/container"
+)
+
+var updateCommand = cli.Command{
+	Name:  "update",
+	Usage: "update resource limits of a running container",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "memory, m",
+			Usage: "memory limit",
+		},
+		cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "memory plus swap limit, -1 for unlimited swap",
+		},
+		cli.StringFlag{
+			Name:  "cpushare",
+			Usage: "cpushare limit",
+		},
+		cli.StringFlag{
+			Name:  "cpuset",
+			Usage: "cpuset limit",
+		},
+		cli.StringFlag{
+			Name:  "cpuset-mems",
+			Usage: "numa nodes to allocate memory on, e.g. 0-1",
+		},
+		cli.StringFlag{
+			Name:  "numa-node",
+			Usage: "pin cpus and memory to one numa node",
+		},
+		cli.StringFlag{
+			Name:  "cpus",
+			Usage: "number of cpus, e.g. 1.5",
+		},
+		cli.StringFlag{
+			Name:  "pids-limit",
+			Usage: "max number of processes in the container, 0 or -1 for unlimited",
+		},
+	},
+	Action: func(context *cli.Context) error {
+		if len(context.Args()) < 1 {
+			return fmt.Errorf("missing container name")
+		}
+		containerName := context.Args().Get(0)
+		return container.UpdateContainer(containerName, func(res *subsystems.ResourceConfig) error {
+			return parseResourceFlags(context, res)
+		})
+	},
+}
@@ -0,0 +1,271 @@
+package container
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ENV_CAPS hands the capabilities of the container to init and exec as a
+// hexadecimal mask.
+const ENV_CAPS = "myrunc_caps"
+
+// capabilityNames are the capabilities by number, see capabilities(7).
+var capabilityNames = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_KILL",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETPCAP",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_ADMIN",
+	"CAP_NET_RAW",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_NICE",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_MKNOD",
+	"CAP_LEASE",
+	"CAP_AUDIT_WRITE",
+	"CAP_AUDIT_CONTROL",
+	"CAP_SETFCAP",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MAC_ADMIN",
+	"CAP_SYSLOG",
+	"CAP_WAKE_ALARM",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_AUDIT_READ",
+	"CAP_PERFMON",
+	"CAP_BPF",
+	"CAP_CHECKPOINT_RESTORE",
+}
+
+// defaultCapabilities are the capabilities of a container without
+// --cap-add and --cap-drop, the default set of docker.
+var defaultCapabilities = []string{
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_FSETID",
+	"CAP_FOWNER",
+	"CAP_MKNOD",
+	"CAP_NET_RAW",
+	"CAP_SETGID",
+	"CAP_SETUID",
+	"CAP_SETFCAP",
+	"CAP_SETPCAP",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_SYS_CHROOT",
+	"CAP_KILL",
+	"CAP_AUDIT_WRITE",
+}
+
+// ParseCapabilities adjusts the default capabilities with --cap-add and
+// --cap-drop. Names are case insensitive and the CAP_ prefix is optional,
+// ALL adds or drops every capability, then the single capabilities are
+// added and dropped. A container can not get more than the bounding set of
+// the runtime, ALL stands for that set.
+func ParseCapabilities(add, drop []string) ([]string, error) {
+	addAll, adds, err := normalizeCapabilities(add)
+	if err != nil {
+		return nil, err
+	}
+	dropAll, drops, err := normalizeCapabilities(drop)
+	if err != nil {
+		return nil, err
+	}
+	for c := range adds {
+		if drops[c] {
+			return nil, fmt.Errorf("capability %s is both added and dropped", c)
+		}
+	}
+
+	available, err := boundingCapabilities()
+	if err != nil {
+		return nil, err
+	}
+	caps := map[string]bool{}
+	if !dropAll {
+		for _, c := range defaultCapabilities {
+			if available&(1<<uint(capabilityNumber(c))) == 0 {
+				logrus.Warnf("capability %s is not in the bounding set of the runtime, skip it", c)
+				continue
+			}
+			caps[c] = true
+		}
+	}
+	for i, c := range capabilityNames {
+		if addAll && available&(1<<uint(i)) != 0 {
+			caps[c] = true
+		}
+	}
+	for c := range adds {
+		if available&(1<<uint(capabilityNumber(c))) == 0 {
+			return nil, fmt.Errorf("capability %s is not in the bounding set of the runtime", c)
+		}
+		caps[c] = true
+	}
+	for c := range drops {
+		delete(caps, c)
+	}
+
+	// ordered by number, like capabilities(7) lists them.
+	result := []string{}
+	for _, c := range capabilityNames {
+		if caps[c] {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+func normalizeCapabilities(names []string) (bool, map[string]bool, error) {
+	all := false
+	caps := map[string]bool{}
+	for _, name := range names {
+		c := strings.ToUpper(strings.TrimSpace(name))
+		if c == "ALL" {
+			all = true
+			continue
+		}
+		if !strings.HasPrefix(c, "CAP_") {
+			c = "CAP_" + c
+		}
+		if capabilityNumber(c) < 0 {
+			return false, nil, fmt.Errorf("unknown capability %s", name)
+		}
+		caps[c] = true
+	}
+	return all, caps, nil
+}
+
+func capabilityNumber(name string) int {
+	for i, c := range capabilityNames {
+		if c == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// boundingCapabilities is the CapBnd mask of the runtime. It only holds
+// capabilities the kernel knows and bounds those of its children, also
+// of root in a user namespace.
+func boundingCapabilities() (uint64, error) {
+	status, err := readStatus(os.Getpid())
+	if err != nil {
+		return 0, fmt.Errorf("read capabilities error; %v", err)
+	}
+	mask, err := strconv.ParseUint(status["CapBnd"], 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse CapBnd %s error; %v", status["CapBnd"], err)
+	}
+	return mask, nil
+}
+
+// lastCap is the highest capability of the kernel, older kernels know
+// fewer than capabilityNames.
+func lastCap() int {
+	content, err := ioutil.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err != nil {
+		return len(capabilityNames) - 1
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || last >= len(capabilityNames) {
+		return len(capabilityNames) - 1
+	}
+	return last
+}
+
+// capabilityMask is the bit mask of capabilities, the format init and
+// exec get them in.
+func capabilityMask(caps []string) uint64 {
+	var mask uint64
+	for _, c := range caps {
+		if n := capabilityNumber(c); n >= 0 {
+			mask |= 1 << uint(n)
+		}
+	}
+	return mask
+}
+
+func capabilitiesOf(mask uint64) []string {
+	caps := []string{}
+	for i, name := range capabilityNames {
+		if mask&(1<<uint(i)) != 0 {
+			caps = append(caps, name)
+		}
+	}
+	return caps
+}
+
+// readInitCapabilities reads the capability mask handed over by the parent.
+func readInitCapabilities() (uint64, error) {
+	content := os.Getenv(ENV_CAPS)
+	os.Unsetenv(ENV_CAPS)
+	mask, err := strconv.ParseUint(content, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse capabilities %s error; %v", content, err)
+	}
+	return mask, nil
+}
+
+// applyCapabilities limits the bounding, effective, permitted, inheritable
+// and ambient sets to mask. The sets belong to the thread, the caller has
+// to stay locked to it until the execve. Root keeps its bounding set over
+// execve, an unprivileged user process its ambient set.
+func applyCapabilities(mask uint64) error {
+	last := lastCap()
+	mask &= 1<<uint(last+1) - 1
+	for i := 0; i <= last; i++ {
+		if mask&(1<<uint(i)) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(i), 0, 0, 0); err != nil {
+			return fmt.Errorf("drop %s from bounding set error; %v", capabilityNames[i], err)
+		}
+	}
+
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	for i := range data {
+		set := uint32(mask >> uint(32*i))
+		data[i] = unix.CapUserData{Effective: set, Permitted: set, Inheritable: set}
+	}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("capset error; %v", err)
+	}
+
+	// kernels before 4.3 have no ambient set.
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		logrus.Warnf("clear ambient capabilities error; %v", err)
+		return nil
+	}
+	for i := 0; i <= last; i++ {
+		if mask&(1<<uint(i)) == 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, uintptr(i), 0, 0); err != nil {
+			return fmt.Errorf("raise ambient %s error; %v", capabilityNames[i], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeCapabilities(t *testing.T) {
+	tests := []struct {
+		input   []string
+		all     bool
+		want    map[string]bool
+		wantErr bool
+	}{
+		{nil, false, map[string]bool{}, false},
+		{[]string{"CAP_CHOWN"}, false, map[string]bool{"CAP_CHOWN": true}, false},
+		{[]string{"chown"}, false, map[string]bool{"CAP_CHOWN": true}, false},
+		{[]string{" Net_Admin "}, false, map[string]bool{"CAP_NET_ADMIN": true}, false},
+		{[]string{"cap_sys_admin", "SYS_ADMIN"}, false, map[string]bool{"CAP_SYS_ADMIN": true}, false},
+		{[]string{"all"}, true, map[string]bool{}, false},
+		{[]string{"ALL", "kill"}, true, map[string]bool{"CAP_KILL": true}, false},
+		{[]string{"CAP_CHECKPOINT_RESTORE"}, false, map[string]bool{"CAP_CHECKPOINT_RESTORE": true}, false},
+		{[]string{"CAP_FOO"}, false, nil, true},
+		{[]string{"CAP_"}, false, nil, true},
+		{[]string{""}, false, nil, true},
+		{[]string{"chown", "bogus"}, false, nil, true},
+	}
+	for _, tt := range tests {
+		all, got, err := normalizeCapabilities(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("normalizeCapabilities(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if all != tt.all || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeCapabilities(%q) = %v, %v, want %v, %v", tt.input, all, got, tt.all, tt.want)
+		}
+	}
+}
+
+func TestCapabilityMask(t *testing.T) {
+	tests := []struct {
+		caps []string
+		mask uint64
+	}{
+		{[]string{}, 0},
+		{[]string{"CAP_CHOWN"}, 1},
+		{[]string{"CAP_CHOWN", "CAP_KILL", "CAP_SYS_ADMIN"}, 1<<0 | 1<<5 | 1<<21},
+		{[]string{"CAP_CHECKPOINT_RESTORE"}, 1 << 40},
+	}
+	for _, tt := range tests {
+		if got := capabilityMask(tt.caps); got != tt.mask {
+			t.Errorf("capabilityMask(%q) = %#x, want %#x", tt.caps, got, tt.mask)
+		}
+		if got := capabilitiesOf(tt.mask); !reflect.DeepEqual(got, tt.caps) {
+			t.Errorf("capabilitiesOf(%#x) = %q, want %q", tt.mask, got, tt.caps)
+		}
+	}
+}
+
+func TestParseCapabilities(t *testing.T) {
+	available, err := boundingCapabilities()
+	if err != nil {
+		t.Skipf("read bounding set: %v", err)
+	}
+	bounded := func(caps ...string) []string {
+		result := []string{}
+		for _, c := range capabilityNames {
+			for _, want := range caps {
+				if c == want && available&(1<<uint(capabilityNumber(c))) != 0 {
+					result = append(result, c)
+				}
+			}
+		}
+		return result
+	}
+	defaults := bounded(defaultCapabilities...)
+	type test struct {
+		name    string
+		add     []string
+		drop    []string
+		want    []string
+		wantErr bool
+	}
+	tests := []test{
+		{"default", nil, nil, defaults, false},
+		{"drop one", nil, []string{"net_raw"}, bounded("CAP_CHOWN", "CAP_DAC_OVERRIDE", "CAP_FSETID", "CAP_FOWNER",
+			"CAP_MKNOD", "CAP_SETGID", "CAP_SETUID", "CAP_SETFCAP", "CAP_SETPCAP", "CAP_NET_BIND_SERVICE",
+			"CAP_SYS_CHROOT", "CAP_KILL", "CAP_AUDIT_WRITE"), false},
+		{"drop all", nil, []string{"ALL"}, []string{}, false},
+		{"drop all add one", []string{"chown"}, []string{"all"}, bounded("CAP_CHOWN"), false},
+		{"add all", []string{"ALL"}, nil, capabilitiesOf(available), false},
+		{"add all drop one", []string{"ALL"}, []string{"CAP_CHOWN"}, capabilitiesOf(available &^ 1), false},
+		{"add twice", []string{"CAP_KILL", "kill"}, nil, defaults, false},
+		{"drop absent", nil, []string{"CAP_SYS_ADMIN"}, defaults, false},
+		{"add and drop", []string{"CAP_KILL"}, []string{"kill"}, nil, true},
+		{"unknown add", []string{"CAP_FOO"}, nil, nil, true},
+		{"unknown drop", nil, []string{"FOO"}, nil, true},
+	}
+	if available&(1<<uint(capabilityNumber("CAP_NET_ADMIN"))) != 0 {
+		tests = append(tests, test{"add one", []string{"net_admin"}, nil, bounded(append([]string{"CAP_NET_ADMIN"}, defaultCapabilities...)...), false})
+	}
+	for i, c := range capabilityNames {
+		if available&(1<<uint(i)) == 0 {
+			tests = append(tests, test{"add outside bounding set", []string{c}, nil, nil, true})
+			break
+		}
+	}
+	for _, tt := range tests {
+		got, err := ParseCapabilities(tt.add, tt.drop)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: ParseCapabilities(%q, %q) error = %v, wantErr %v", tt.name, tt.add, tt.drop, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: ParseCapabilities(%q, %q) = %q, want %q", tt.name, tt.add, tt.drop, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package container
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"toy-runc/internal/cgroups"
+)
+
+const (
+	ENV_INIT_CGROUPNS        = "myrunc_cgroupns"
+	ENV_INIT_DELEGATE_CGROUP = "myrunc_delegate_cgroup"
+
+	// CgroupnsPrivate gives the container its own cgroup namespace rooted
+	// at the container cgroup.
+	CgroupnsPrivate = "private"
+	// CgroupnsHost keeps the container in the cgroup namespace of the host.
+	CgroupnsHost = "host"
+
+	cgroupMountPoint = "/sys/fs/cgroup"
+	// delegatedLeaf is the cgroup init moves into inside a delegated
+	// subtree, on v2 only a cgroup without processes of its own can enable
+	// controllers for its children.
+	delegatedLeaf = "init"
+)
+
+// ParseCgroupns checks the --cgroupns mode, without one a container gets a
+// private namespace on unified hosts and the host namespace otherwise, the
+// same defaults docker picks.
+func ParseCgroupns(mode string) (string, error) {
+	switch mode {
+	case CgroupnsPrivate, CgroupnsHost:
+		return mode, nil
+	case "":
+		if cgroupMode, _ := cgroups.GetCgroupMode(); cgroupMode == cgroups.Unified {
+			return CgroupnsPrivate, nil
+		}
+		return CgroupnsHost, nil
+	}
+	return "", fmt.Errorf("invalid cgroupns %s, must be %s or %s", mode, CgroupnsPrivate, CgroupnsHost)
+}
+
+// CheckDelegateCgroup makes sure a delegated cgroup can be handed to the
+// container: the controllers must be on cgroup v2 and the container needs
+// its own cgroup namespace so its cgroup shows up as the root.
+func CheckDelegateCgroup(cgroupns string) error {
+	if mode, _ := cgroups.GetCgroupMode(); mode != cgroups.Unified {
+		return fmt.Errorf("delegate-cgroup needs a cgroup v2 host, this one is %s", mode)
+	}
+	if cgroupns != CgroupnsPrivate {
+		return fmt.Errorf("delegate-cgroup needs cgroupns %s", CgroupnsPrivate)
+	}
+	if !cgroups.HasNsdelegate() {
+		logrus.Warnf("cgroup2 is not mounted with nsdelegate, the container can write the limits of its own cgroup")
+	}
+	return nil
+}
+
+// readInitDelegateCgroup reads whether the parent delegated the cgroup and
+// drops the variable so it does not leak into the user process.
+func readInitDelegateCgroup() bool {
+	delegate := os.Getenv(ENV_INIT_DELEGATE_CGROUP) == "1"
+	os.Unsetenv(ENV_INIT_DELEGATE_CGROUP)
+	return delegate
+}
+
+// readInitCgroupns reads the cgroupns mode handed over by the parent and
+// drops the variable so it does not leak into the user process.
+func readInitCgroupns() string {
+	mode := os.Getenv(ENV_INIT_CGROUPNS)
+	os.Unsetenv(ENV_INIT_CGROUPNS)
+	return mode
+}
+
+// unshareCgroupns moves init into a new cgroup namespace. The root of a
+// cgroup namespace is the cgroup the process is in when the namespace is
+// created, so unlike the other namespaces it can not be set up at clone
+// time: the parent only applies the cgroup after the clone. Init unshares
+// once the parent closed the command pipe, by then it sits in its cgroup.
+// unshare only moves the calling thread, init stays on it until the exec.
+func unshareCgroupns() error {
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWCGROUP); err != nil {
+		return fmt.Errorf("unshare cgroup namespace error; %v", err)
+	}
+	return nil
+}
+
+// mountCgroups mounts the hierarchies of the host under /sys/fs/cgroup of
+// the container, read only unless the cgroup is delegated. Inside the cgroup
+// namespace each of them only shows the subtree of the container cgroup.
+func mountCgroups(mounts []cgroups.Mount, delegate bool) error {
+	flags := uintptr(unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC)
+	unifiedFlags := flags | unix.MS_RDONLY
+	if delegate {
+		unifiedFlags = flags
+	}
+	if err := os.MkdirAll(cgroupMountPoint, 0755); err != nil {
+		return fmt.Errorf("mkdir %s error; %v", cgroupMountPoint, err)
+	}
+
+	// on unified hosts the single hierarchy is /sys/fs/cgroup itself.
+	for _, mount := range mounts {
+		if mount.Mountpoint == cgroupMountPoint && mount.Fstype == "cgroup2" {
+			if err := unix.Mount("cgroup2", cgroupMountPoint, "cgroup2", unifiedFlags, ""); err != nil {
+				return fmt.Errorf("mount cgroup2 error; %v", err)
+			}
+			return nil
+		}
+	}
+
+	if err := unix.Mount("tmpfs", cgroupMountPoint, "tmpfs", flags, "mode=755"); err != nil {
+		return fmt.Errorf("mount tmpfs on %s error; %v", cgroupMountPoint, err)
+	}
+	seen := map[string]bool{}
+	for _, mount := range mounts {
+		if !strings.HasPrefix(mount.Mountpoint, cgroupMountPoint+"/") || seen[mount.Mountpoint] {
+			continue
+		}
+		seen[mount.Mountpoint] = true
+		if err := os.MkdirAll(mount.Mountpoint, 0755); err != nil {
+			return fmt.Errorf("mkdir %s error; %v", mount.Mountpoint, err)
+		}
+		// a v1 hierarchy is only mounted again with the same controllers.
+		var data string
+		if mount.Fstype == "cgroup" {
+			data = hierarchyOptions(mount.Options)
+		}
+		if err := unix.Mount("cgroup", mount.Mountpoint, mount.Fstype, flags|unix.MS_RDONLY, data); err != nil {
+			return fmt.Errorf("mount %s on %s error; %v", mount.Fstype, mount.Mountpoint, err)
+		}
+	}
+	if err := unix.Mount("", cgroupMountPoint, "", flags|unix.MS_REMOUNT|unix.MS_RDONLY, "mode=755"); err != nil {
+		return fmt.Errorf("remount %s read only error; %v", cgroupMountPoint, err)
+	}
+	return nil
+}
+
+// enterDelegatedLeaf moves init out of the root of its delegated subtree
+// into a leaf and enables every controller of the root for its children,
+// the same dance systemd and docker-in-docker do on start. The limits of
+// the container keep applying to the whole subtree.
+func enterDelegatedLeaf() error {
+	leaf := path.Join(cgroupMountPoint, delegatedLeaf)
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("mkdir %s error; %v", leaf, err)
+	}
+	// 0 stands for the writing process, with all its threads.
+	if err := ioutil.WriteFile(path.Join(leaf, "cgroup.procs"), []byte("0"), 0644); err != nil {
+		return fmt.Errorf("move init to %s error; %v", leaf, err)
+	}
+	return cgroups.EnableControllers(cgroupMountPoint)
+}
+
+// hierarchyOptions drops rw and ro from the super options of a v1 hierarchy.
+func hierarchyOptions(options string) string {
+	var result []string
+	for _, option := range strings.Split(options, ",") {
+		if option != "rw" && option != "ro" {
+			result = append(result, option)
+		}
+	}
+	return strings.Join(result, ",")
+}
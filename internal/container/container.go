@@ -15,37 +15,62 @@ import (
 	"syscall"
 	"text/tabwriter"
 	"time"
+	"toy-runc/internal/cgroups"
+	"toy-runc/internal/cgroups/subsystems"
 )
 
 const (
 	ENV_EXEC_PID = "myrunc_pid"
 	ENV_EXEC_CMD = "myrunc_cmd"
+	// ENV_EXEC_USERNS makes exec join the user namespace of the container.
+	ENV_EXEC_USERNS = "myrunc_userns"
 )
 
 var (
 	RUNNING             = "running"
+	PAUSED              = "paused"
 	STOP                = "stopped"
 	Exit                = "exited"
 	DefaultInfoLocation = "/var/run/myRunc/%s/"
 	ContainerLogFile    = "container.log"
 	ConfigName          = "config.json"
 	RootUrl             = "/root"
+	LayerUrl            = "/root"
+	RemapRootUrl        = "/var/lib/toy-runc"
 	MntUrl              = "/root/mnt/%s"
 	WriteLayerUrl       = "/root/writeLayer/%s"
+	DefaultCgroupParent = "toy-runc"
 )
 
 type ContainerInfo struct {
-	Pid         string   `json:"pid"`
-	Id          string   `json:"id"`
-	Name        string   `json:"name"`
-	Command     string   `json:"command"`
-	CreatedTime string   `json:"createdTime"`
-	Status      string   `json:"status"`
-	Volume      string   `json:"volume"`
-	PortMapping []string `json:"portmapping"`
+	Pid         string                     `json:"pid"`
+	Id          string                     `json:"id"`
+	Name        string                     `json:"name"`
+	Command     string                     `json:"command"`
+	CreatedTime string                     `json:"createdTime"`
+	Status      string                     `json:"status"`
+	Volume      string                     `json:"volume"`
+	PortMapping []string                   `json:"portmapping"`
+	CgroupPath  string                     `json:"cgroupPath"`
+	Resources   *subsystems.ResourceConfig `json:"resources"`
+	Cgroupns    string                     `json:"cgroupns"`
+	// DelegateCgroup is set when the container manages the subtree below its cgroup.
+	DelegateCgroup bool `json:"delegateCgroup"`
+	// IDMappings are set when the container runs in its own user namespace.
+	IDMappings *IDMappings `json:"idMappings,omitempty"`
+	// Security holds the --security-opt options.
+	Security *SecurityConfig `json:"security,omitempty"`
+	// Capabilities are the capabilities of init and of exec'd processes,
+	// nil for containers of older versions, which keep every capability.
+	Capabilities []string `json:"capabilities"`
+	// OOMKilled is set once the kernel oom killed any task of the container.
+	OOMKilled    bool   `json:"oomKilled"`
+	OOMKillCount uint64 `json:"oomKillCount"`
 }
 
-func RecordContainerInfo(containerPID int, commandArray []string, containerName, containerId string, volume string) (string, error) {
+func RecordContainerInfo(containerPID int, commandArray []string, containerName, containerId string, volume string, cgroupPath string,
+	res *subsystems.ResourceConfig, cgroupns string, delegateCgroup bool,
+	idMappings *IDMappings, caps []string, security *SecurityConfig) (string, error) {
 	createTime := time.Now().Format("2006-01-02 15:04:05")
 	command := strings.Join(commandArray, "")
 	containerInfo := &ContainerInfo{
@@ -56,6 +81,14 @@ func RecordContainerInfo(containerPID int, commandArray []string, containerName,
 		CreatedTime: createTime,
 		Status:      RUNNING,
 		Volume:      volume,
+		CgroupPath:  cgroupPath,
+		Resources:   res,
+		Cgroupns:    cgroupns,
+
+		DelegateCgroup: delegateCgroup,
+		IDMappings:     idMappings,
+		Capabilities:   caps,
+		Security:       security,
 	}
 	jsonBytes, err := json.Marshal(containerInfo)
 	if err != nil {
@@ -65,7 +98,7 @@ func RecordContainerInfo(containerPID int, commandArray []string, containerName,
 	jsonStr := string(jsonBytes)
 
 	dirUrl := fmt.Sprintf(DefaultInfoLocation, containerName)
-	if err := os.MkdirAll(dirUrl, 0622); err != nil {
+	if err := os.MkdirAll(dirUrl, 0755); err != nil {
 		logrus.Errorf("mkdir error %s, error %v", dirUrl, err)
 		return "", err
 	}
@@ -79,10 +112,20 @@ func RecordContainerInfo(containerPID int, commandArray []string, containerName,
 		logrus.Errorf("file write string error; %v", err)
 		return "", err
 	}
+	if security != nil && security.SeccompProfile != nil {
+		if err := recordSeccompProfile(containerName, security.SeccompProfile); err != nil {
+			logrus.Errorf("record container seccomp profile error; %v", err)
+			return "", err
+		}
+	}
 	return containerName, nil
 }
 
 func DeleteContainerInfo(containerId string) {
+	// wait for a running oom watcher to finish its write.
+	if unlock, err := lockContainerInfo(containerId); err == nil {
+		defer unlock()
+	}
 	dirUrl := fmt.Sprintf(DefaultInfoLocation, containerId)
 	if err := os.RemoveAll(dirUrl); err != nil {
 		logrus.Errorf("remove dir %s error; %v", dirUrl, err)
@@ -110,7 +153,8 @@ func getContainerInfo(file os.FileInfo) (*ContainerInfo, error) {
 // NewParentProcess create the execution env for the current process.
 // /proc/self/exe represent current program
 // create namespace-isolated container processes.
-func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string) (*exec.Cmd, *os.File) {
+func NewParentProcess(tty bool, containerName, volume, imageName string, envSlice []string, devices []*Device, cgroupns string,
+	delegateCgroup bool, idMappings *IDMappings, caps []string, security *SecurityConfig) (*exec.Cmd, *os.File) {
 	readPipe, writePipe, err := newPipe()
 	if err != nil {
 		logrus.Errorf("new pipe error %v", err)
@@ -122,13 +166,23 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 		Cloneflags: syscall.CLONE_NEWUTS | syscall.CLONE_NEWPID | syscall.CLONE_NEWNS |
 			syscall.CLONE_NEWNET | syscall.CLONE_NEWIPC,
 	}
+	if idMappings != nil {
+		// init runs as root of the user namespace, the namespaces created
+		// along with it belong to it.
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER
+		cmd.SysProcAttr.UidMappings = sysProcIDMaps(idMappings.UidMappings)
+		cmd.SysProcAttr.GidMappings = sysProcIDMaps(idMappings.GidMappings)
+		// an unprivileged parent must deny setgroups to write the gid map.
+		cmd.SysProcAttr.GidMappingsEnableSetgroups = !Rootless
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: Rootless}
+	}
 	if tty {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 	} else {
 		dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-		if err := os.MkdirAll(dirURL, 0622); err != nil {
+		if err := os.MkdirAll(dirURL, 0755); err != nil {
 			logrus.Errorf("NewParentProcess mkdir %s error; %v", dirURL, err)
 			return nil, nil
 		}
@@ -143,19 +197,53 @@ func NewParentProcess(tty bool, containerName, volume, imageName string, envSlic
 
 	cmd.ExtraFiles = []*os.File{readPipe}
 	cmd.Env = append(os.Environ(), envSlice...)
+	if len(devices) > 0 {
+		devicesBytes, err := json.Marshal(devices)
+		if err != nil {
+			logrus.Errorf("NewParentProcess marshal devices error; %v", err)
+			return nil, nil
+		}
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", ENV_INIT_DEVICES, devicesBytes))
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", ENV_INIT_CGROUPNS, cgroupns))
+	if delegateCgroup {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=1", ENV_INIT_DELEGATE_CGROUP))
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%x", ENV_CAPS, capabilityMask(caps)))
+	securityBytes, err := json.Marshal(security)
+	if err != nil {
+		logrus.Errorf("NewParentProcess marshal security config error; %v", err)
+		return nil, nil
+	}
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", ENV_INIT_SECURITY, securityBytes))
+	if security.SeccompProfile != nil {
+		seccompBytes, err := json.Marshal(security.SeccompProfile)
+		if err != nil {
+			logrus.Errorf("NewParentProcess marshal seccomp profile error; %v", err)
+			return nil, nil
+		}
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", ENV_INIT_SECCOMP, seccompBytes))
+	}
 	logrus.Infof("runC recv run command; %s", cmd.String())
-	newWorkSpace(volume, imageName, containerName)
+	if mounts := newWorkSpace(volume, imageName, containerName, idMappings); len(mounts) > 0 {
+		mountsBytes, err := json.Marshal(mounts)
+		if err != nil {
+			logrus.Errorf("NewParentProcess marshal mounts error; %v", err)
+			return nil, nil
+		}
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", ENV_INIT_MOUNTS, mountsBytes))
+	}
 	cmd.Dir = fmt.Sprintf(MntUrl, containerName)
 	return cmd, writePipe
 }
 
-func ListContainer() {
+func listContainerInfos() ([]*ContainerInfo, error) {
 	dirUrl := fmt.Sprintf(DefaultInfoLocation, "")
 	dirUrl = dirUrl[:len(dirUrl)-1]
 	files, err := ioutil.ReadDir(dirUrl)
 	if err != nil {
 		logrus.Errorf("read dir %s error; %v", dirUrl, err)
-		return
+		return nil, err
 	}
 	var containers []*ContainerInfo
 	for _, file := range files {
@@ -166,14 +254,23 @@ func ListContainer() {
 		}
 		containers = append(containers, tmpContainer)
 	}
+	return containers, nil
+}
+
+func ListContainer() {
+	containers, err := listContainerInfos()
+	if err != nil {
+		return
+	}
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
-	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
+	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tOOMKILLED\tCOMMAND\tCREATED\n")
 	for _, item := range containers {
-		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
 			item.Id,
 			item.Name,
 			item.Pid,
 			item.Status,
+			formatOOMKilled(item),
 			item.Command,
 			item.CreatedTime,
 		)
@@ -184,6 +281,13 @@ func ListContainer() {
 	}
 }
 
+func formatOOMKilled(containerInfo *ContainerInfo) string {
+	if !containerInfo.OOMKilled {
+		return "false"
+	}
+	return fmt.Sprintf("true (%d)", containerInfo.OOMKillCount)
+}
+
 func LogContainer(containerName string) {
 	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
 	logFileLocation := dirURL + ContainerLogFile
@@ -201,12 +305,13 @@ func LogContainer(containerName string) {
 	fmt.Fprint(os.Stdout, string(content))
 }
 
-func ExecContainer(containerName string, cmdArray []string) {
-	pid, err := getContainerPidByName(containerName)
+func ExecContainer(containerName string, cmdArray []string, security *SecurityConfig) {
+	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
-		logrus.Errorf("exec container getContainerPidByName %s error; %v", containerName, err)
+		logrus.Errorf("exec container getContainerInfoByName %s error; %v", containerName, err)
 		return
 	}
+	pid := containerInfo.Pid
 	cmdStr := strings.Join(cmdArray, " ")
 	logrus.Infof("container pid %s", pid)
 	logrus.Infof("command %s", cmdStr)
@@ -218,6 +323,25 @@ func ExecContainer(containerName string, cmdArray []string) {
 
 	os.Setenv(ENV_EXEC_PID, pid)
 	os.Setenv(ENV_EXEC_CMD, cmdStr)
+	if containerInfo.IDMappings != nil {
+		os.Setenv(ENV_EXEC_USERNS, "1")
+	}
+	// no_new_privs of the container also holds for exec'd processes.
+	if security.NoNewPrivileges || (containerInfo.Security != nil && containerInfo.Security.NoNewPrivileges) {
+		os.Setenv(ENV_EXEC_NO_NEW_PRIVS, "1")
+	}
+	if containerInfo.Capabilities != nil {
+		os.Setenv(ENV_CAPS, fmt.Sprintf("%x", capabilityMask(containerInfo.Capabilities)))
+	}
+	// exec'd processes are filtered like the init process.
+	filter, err := execSeccompFilter(containerInfo)
+	if err != nil {
+		logrus.Errorf("exec container %s error; %v", containerName, err)
+		return
+	}
+	if filter != "" {
+		os.Setenv(ENV_EXEC_SECCOMP, filter)
+	}
 	containerEnvs := getEnvsByPid(pid)
 
 	cmd.Env = append(os.Environ(), containerEnvs...)
@@ -228,6 +352,12 @@ func ExecContainer(containerName string, cmdArray []string) {
 }
 
 func StopContainer(containerName string) {
+	unlock, err := lockContainerInfo(containerName)
+	if err != nil {
+		logrus.Errorf("lock container %s info error; %v", containerName, err)
+		return
+	}
+	defer unlock()
 	pid, err := getContainerPidByName(containerName)
 	if err != nil {
 		logrus.Errorf("get container pid by name %s error; %v", containerName, err)
@@ -240,40 +370,120 @@ func StopContainer(containerName string) {
 		return
 	}
 
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		logrus.Errorf("get container %s info error; %v", containerName, err)
+		return
+	}
+
+	// a frozen task never handles the signal, thaw it first.
+	if containerInfo.Status == PAUSED {
+		cgroupManager, err := containerCgroup(containerInfo)
+		if err != nil {
+			logrus.Errorf("stop container %s error; %v", containerName, err)
+			return
+		}
+		if err := cgroupManager.Freeze(subsystems.Thawed); err != nil {
+			logrus.Errorf("unpause container %s error; %v", containerName, err)
+		}
+	}
+
 	if err := syscall.Kill(pidInt, syscall.SIGTERM); err != nil {
 		logrus.Errorf("stop container %s error; %v", containerName, err)
 	}
 
+	containerInfo.Status = STOP
+	containerInfo.Pid = " "
+	if err := writeContainerInfo(containerInfo); err != nil {
+		logrus.Errorf("write container %s info error; %v", containerName, err)
+	}
+}
+
+// PauseContainer freezes every task in the container's cgroup.
+func PauseContainer(containerName string) {
+	unlock, err := lockContainerInfo(containerName)
+	if err != nil {
+		logrus.Errorf("lock container %s info error; %v", containerName, err)
+		return
+	}
+	defer unlock()
 	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
 		logrus.Errorf("get container %s info error; %v", containerName, err)
 		return
 	}
+	if containerInfo.Status != RUNNING {
+		logrus.Errorf("container %s is not running", containerName)
+		return
+	}
+	cgroupManager, err := containerCgroup(containerInfo)
+	if err != nil {
+		logrus.Errorf("pause container %s error; %v", containerName, err)
+		return
+	}
+	if err := cgroupManager.Freeze(subsystems.Frozen); err != nil {
+		logrus.Errorf("pause container %s error; %v", containerName, err)
+		return
+	}
+	containerInfo.Status = PAUSED
+	if err := writeContainerInfo(containerInfo); err != nil {
+		logrus.Errorf("write container %s info error; %v", containerName, err)
+	}
+}
 
-	containerInfo.Status = STOP
-	containerInfo.Pid = " "
-	newContentBytes, err := json.Marshal(containerInfo)
+// UnpauseContainer thaws every task in the container's cgroup.
+func UnpauseContainer(containerName string) {
+	unlock, err := lockContainerInfo(containerName)
 	if err != nil {
-		logrus.Errorf("json marshal %s,error; %v", containerName, err)
+		logrus.Errorf("lock container %s info error; %v", containerName, err)
 		return
 	}
-	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
-	configFilePath := dirURL + ConfigName
-	if err := ioutil.WriteFile(configFilePath, newContentBytes, 0622); err != nil {
-		logrus.Errorf("write file %s error; %v", configFilePath, err)
+	defer unlock()
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		logrus.Errorf("get container %s info error; %v", containerName, err)
+		return
+	}
+	if containerInfo.Status != PAUSED {
+		logrus.Errorf("container %s is not paused", containerName)
+		return
+	}
+	cgroupManager, err := containerCgroup(containerInfo)
+	if err != nil {
+		logrus.Errorf("unpause container %s error; %v", containerName, err)
+		return
+	}
+	if err := cgroupManager.Freeze(subsystems.Thawed); err != nil {
+		logrus.Errorf("unpause container %s error; %v", containerName, err)
+		return
+	}
+	containerInfo.Status = RUNNING
+	if err := writeContainerInfo(containerInfo); err != nil {
+		logrus.Errorf("write container %s info error; %v", containerName, err)
 	}
 }
 
 func RemoveContainer(containerName string) {
+	unlock, err := lockContainerInfo(containerName)
+	if err != nil {
+		logrus.Errorf("lock container %s info error; %v", containerName, err)
+		return
+	}
+	defer unlock()
 	containerInfo, err := getContainerInfoByName(containerName)
 	if err != nil {
 		logrus.Errorf("get container %s info error; %v", containerName, err)
 		return
 	}
-	if containerInfo.Status != STOP {
+	if containerInfo.Status != STOP && containerInfo.Status != Exit {
 		logrus.Errorf("could't remove running container")
 		return
 	}
+	if containerInfo.CgroupPath != "" {
+		if err := cgroups.NewCgroupManager(containerInfo.CgroupPath).Destroy(); err != nil {
+			logrus.Errorf("remove container %s cgroup error; %v", containerName, err)
+		}
+	}
 	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
 	if err := os.RemoveAll(dirURL); err != nil {
 		logrus.Errorf("remove file %s error; %v", dirURL, err)
@@ -0,0 +1,175 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strings"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+const ENV_INIT_DEVICES = "myrunc_devices"
+
+// Device is a device node created inside the container, Path is the
+// location inside the container and HostPath the node it comes from.
+type Device struct {
+	subsystems.DeviceRule
+	Path     string      `json:"path"`
+	HostPath string      `json:"hostPath"`
+	FileMode os.FileMode `json:"fileMode"`
+	Uid      uint32      `json:"uid"`
+	Gid      uint32      `json:"gid"`
+}
+
+// defaultDevices are created in every container, they are all covered by
+// subsystems.DefaultAllowedDevices.
+var defaultDevices = []*Device{
+	{DeviceRule: subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 1, Minor: 3, Permissions: "rwm"}, Path: "/dev/null", HostPath: "/dev/null", FileMode: 0666},
+	{DeviceRule: subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 1, Minor: 5, Permissions: "rwm"}, Path: "/dev/zero", HostPath: "/dev/zero", FileMode: 0666},
+	{DeviceRule: subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 1, Minor: 7, Permissions: "rwm"}, Path: "/dev/full", HostPath: "/dev/full", FileMode: 0666},
+	{DeviceRule: subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 1, Minor: 8, Permissions: "rwm"}, Path: "/dev/random", HostPath: "/dev/random", FileMode: 0666},
+	{DeviceRule: subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 1, Minor: 9, Permissions: "rwm"}, Path: "/dev/urandom", HostPath: "/dev/urandom", FileMode: 0666},
+	{DeviceRule: subsystems.DeviceRule{Type: subsystems.CharDevice, Major: 5, Minor: 0, Permissions: "rwm"}, Path: "/dev/tty", HostPath: "/dev/tty", FileMode: 0666},
+}
+
+// ParseDevice parses "/dev/xyz[:/dev/abc][:rwm]" and looks the host device up.
+func ParseDevice(spec string) (*Device, error) {
+	parts := strings.Split(spec, ":")
+	hostPath, containerPath, permissions := parts[0], parts[0], "rwm"
+	switch len(parts) {
+	case 1:
+	case 2:
+		if isDevicePermissions(parts[1]) {
+			permissions = parts[1]
+		} else {
+			containerPath = parts[1]
+		}
+	case 3:
+		containerPath, permissions = parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("invalid device %s", spec)
+	}
+	if hostPath == "" || containerPath == "" || !isDevicePermissions(permissions) {
+		return nil, fmt.Errorf("invalid device %s", spec)
+	}
+	if !filepath.IsAbs(containerPath) {
+		return nil, fmt.Errorf("device path %s in container must be absolute", containerPath)
+	}
+
+	var stat unix.Stat_t
+	if err := unix.Stat(hostPath, &stat); err != nil {
+		return nil, fmt.Errorf("stat device %s error; %v", hostPath, err)
+	}
+	var deviceType string
+	switch stat.Mode & unix.S_IFMT {
+	case unix.S_IFCHR:
+		deviceType = subsystems.CharDevice
+	case unix.S_IFBLK:
+		deviceType = subsystems.BlockDevice
+	default:
+		return nil, fmt.Errorf("%s is not a device", hostPath)
+	}
+	return &Device{
+		DeviceRule: subsystems.DeviceRule{
+			Type:        deviceType,
+			Major:       int64(unix.Major(stat.Rdev)),
+			Minor:       int64(unix.Minor(stat.Rdev)),
+			Permissions: permissions,
+		},
+		Path:     containerPath,
+		HostPath: hostPath,
+		FileMode: os.FileMode(stat.Mode &^ unix.S_IFMT),
+		Uid:      stat.Uid,
+		Gid:      stat.Gid,
+	}, nil
+}
+
+func isDevicePermissions(permissions string) bool {
+	if permissions == "" {
+		return false
+	}
+	for _, perm := range permissions {
+		if !strings.ContainsRune("rwm", perm) {
+			return false
+		}
+	}
+	return true
+}
+
+// DeviceRules returns the cgroup rules allowing devices.
+func DeviceRules(devices []*Device) []subsystems.DeviceRule {
+	var rules []subsystems.DeviceRule
+	for _, device := range devices {
+		rules = append(rules, device.DeviceRule)
+	}
+	return rules
+}
+
+// readInitDevices reads the devices handed over by the parent and drops the
+// variable so it does not leak into the user process.
+func readInitDevices() []*Device {
+	devices := defaultDevices
+	content := os.Getenv(ENV_INIT_DEVICES)
+	os.Unsetenv(ENV_INIT_DEVICES)
+	if content == "" {
+		return devices
+	}
+	var extra []*Device
+	if err := json.Unmarshal([]byte(content), &extra); err != nil {
+		logrus.Errorf("unmarshal init devices error; %v", err)
+		return devices
+	}
+	return append(devices, extra...)
+}
+
+// createDeviceNodes mknods every device in the freshly mounted /dev below
+// root. A user namespace may not mknod, there the host nodes are bind
+// mounted instead, which is why this runs before the pivot.
+func createDeviceNodes(root string, devices []*Device) error {
+	// the umask would strip the permission bits of the nodes.
+	oldMask := unix.Umask(0)
+	defer unix.Umask(oldMask)
+
+	userns := runningInUserNS()
+	for _, device := range devices {
+		dest := filepath.Join(root, device.Path)
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("mkdir %s error; %v", filepath.Dir(device.Path), err)
+		}
+		if userns {
+			if err := bindDevice(device, dest); err != nil {
+				return err
+			}
+			continue
+		}
+		mode := uint32(device.FileMode.Perm())
+		if device.Type == subsystems.BlockDevice {
+			mode |= unix.S_IFBLK
+		} else {
+			mode |= unix.S_IFCHR
+		}
+		dev := int(unix.Mkdev(uint32(device.Major), uint32(device.Minor)))
+		if err := unix.Mknod(dest, mode, dev); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("mknod %s error; %v", device.Path, err)
+		}
+		if err := os.Chown(dest, int(device.Uid), int(device.Gid)); err != nil {
+			return fmt.Errorf("chown %s error; %v", device.Path, err)
+		}
+	}
+	return nil
+}
+
+func bindDevice(device *Device, dest string) error {
+	f, err := os.OpenFile(dest, os.O_CREATE, 0000)
+	if err != nil {
+		return fmt.Errorf("create %s error; %v", device.Path, err)
+	}
+	f.Close()
+	if err := unix.Mount(device.HostPath, dest, "", unix.MS_BIND, ""); err != nil {
+		return fmt.Errorf("bind mount device %s error; %v", device.HostPath, err)
+	}
+	return nil
+}
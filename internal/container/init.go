@@ -4,12 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"syscall"
+	"toy-runc/internal/cgroups"
 )
 
 var (
@@ -24,13 +27,55 @@ func RunContainerInitProcess() error {
 	if cmdArray == nil || len(cmdArray) == 0 {
 		return containerInitCmdError
 	}
+	devices := readInitDevices()
+	mounts := readInitMounts()
+	security, err := readInitSecurity()
+	if err != nil {
+		logrus.Errorf("init %v", err)
+		return nil
+	}
+	caps, err := readInitCapabilities()
+	if err != nil {
+		logrus.Errorf("init %v", err)
+		return nil
+	}
+
+	delegate := readInitDelegateCgroup()
+
+	// the command pipe is closed after the parent applied the cgroup.
+	var cgroupMounts []cgroups.Mount
+	if readInitCgroupns() == CgroupnsPrivate {
+		mounts, err := cgroups.GetCgroupMounts()
+		if err != nil {
+			logrus.Errorf("init read cgroup mounts error; %v", err)
+			return nil
+		}
+		cgroupMounts = mounts
+		if err := unshareCgroupns(); err != nil {
+			logrus.Errorf("init %v", err)
+			return nil
+		}
+	}
 
 	// init mount point.
-	if err := setUpMount(); err != nil {
+	if err := setUpMount(mounts, devices); err != nil {
 		logrus.Errorf("init set mount error; %v", err)
 		return nil
 	}
 
+	if cgroupMounts != nil {
+		if err := mountCgroups(cgroupMounts, delegate); err != nil {
+			logrus.Errorf("init mount cgroups error; %v", err)
+			return nil
+		}
+		if delegate {
+			if err := enterDelegatedLeaf(); err != nil {
+				logrus.Errorf("init enter delegated cgroup error; %v", err)
+				return nil
+			}
+		}
+	}
+
 	logrus.Infof("current path: %s", os.Getenv("PATH"))
 
 	path, err := exec.LookPath(cmdArray[0])
@@ -47,6 +92,39 @@ func RunContainerInitProcess() error {
 
 	logrus.Infof("find path %s", path)
 
+	// capabilities belong to the thread, the user process has to be
+	// executed from the one they are applied to.
+	runtime.LockOSThread()
+
+	// no_new_privs is kept over execve and inherited, it also lets an
+	// unprivileged process install a seccomp filter.
+	if security.NoNewPrivileges {
+		if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+			logrus.Errorf("init set no_new_privs error; %v", err)
+			return nil
+		}
+	}
+
+	// the filter also covers the execve, the profile has to allow it.
+	// without no_new_privs installing it needs CAP_SYS_ADMIN, so it goes
+	// before the capabilities are dropped, with it as late as possible.
+	if security.SeccompProfile != nil && !security.NoNewPrivileges {
+		if err := installSeccomp(security.SeccompProfile, capabilitiesOf(caps)); err != nil {
+			logrus.Errorf("init install seccomp error; %v", err)
+			return nil
+		}
+	}
+	if err := applyCapabilities(caps); err != nil {
+		logrus.Errorf("init apply capabilities error; %v", err)
+		return nil
+	}
+	if security.SeccompProfile != nil && security.NoNewPrivileges {
+		if err := installSeccomp(security.SeccompProfile, capabilitiesOf(caps)); err != nil {
+			logrus.Errorf("init install seccomp error; %v", err)
+			return nil
+		}
+	}
+
 	// call int execve(cosnt char*filename, char*const argv[], char*const envp[]);
 	if err := syscall.Exec(path, cmdArray[0:], os.Environ()); err != nil {
 		logrus.Errorf(err.Error())
@@ -65,38 +143,65 @@ func readUserCommand() []string {
 	return strings.Split(msgStr, " ")
 }
 
-func setUpMount() error {
+func setUpMount(mounts []*rootfsMount, devices []*Device) error {
 	pwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("pwd error:%v", err)
 	}
 
-	logrus.Infof("current location: %s", pwd)
-	if err = pivotRoot(pwd); err != nil {
+	// systemd 加入linux之后, mount namespace 就变成 shared by default, 必须显式声明新的mount namespace独立。
+	err = syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
+	if err != nil {
 		return err
 	}
 
-	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
-	err = syscall.Mount("proc", "/proc", "proc", uintptr(defaultMountFlags), "")
-	if err != nil {
-		return fmt.Errorf("mount proc error: %v", err)
+	// the rootfs of a rootless container is mounted here, the working
+	// directory still points below the fresh overlay.
+	for _, mount := range mounts {
+		if err := os.MkdirAll(mount.Target, 0755); err != nil {
+			return fmt.Errorf("mkdir %s error: %v", mount.Target, err)
+		}
+		if err := syscall.Mount(mount.Source, mount.Target, mount.Fstype, mount.Flags, mount.Data); err != nil {
+			return fmt.Errorf("mount %s on %s error: %v", mount.Source, mount.Target, err)
+		}
+	}
+	if len(mounts) > 0 {
+		if err := os.Chdir(pwd); err != nil {
+			return fmt.Errorf("chdir %s error: %v", pwd, err)
+		}
 	}
 
-	err = syscall.Mount("tmpfs", "/dev", "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
+	// /dev and /proc are set up before the pivot while the host nodes are
+	// still reachable.
+	devDir := filepath.Join(pwd, "dev")
+	if err := os.MkdirAll(devDir, 0755); err != nil {
+		return fmt.Errorf("mkdir %s error: %v", devDir, err)
+	}
+	err = syscall.Mount("tmpfs", devDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_STRICTATIME, "mode=755")
 	if err != nil {
 		return fmt.Errorf("mount tmpfs error: %v", err)
 	}
+	if err := createDeviceNodes(pwd, devices); err != nil {
+		return err
+	}
 
-	return nil
-}
-
-func pivotRoot(root string) error {
-	// systemd 加入linux之后, mount namespace 就变成 shared by default, 必须显式声明新的mount namespace独立。
-	err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, "")
+	// a user namespace may only mount proc while the proc of the host is
+	// still visible.
+	procDir := filepath.Join(pwd, "proc")
+	if err := os.MkdirAll(procDir, 0555); err != nil {
+		return fmt.Errorf("mkdir %s error: %v", procDir, err)
+	}
+	defaultMountFlags := syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV
+	err = syscall.Mount("proc", procDir, "proc", uintptr(defaultMountFlags), "")
 	if err != nil {
-		return err
+		return fmt.Errorf("mount proc error: %v", err)
 	}
 
+	logrus.Infof("current location: %s", pwd)
+	return pivotRoot(pwd)
+}
+
+func pivotRoot(root string) error {
 	// 重新mount root
 	// bind mount：将相同内容换挂载点
 	if err := syscall.Mount(root, root, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
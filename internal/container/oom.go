@@ -0,0 +1,111 @@
+package container
+
+import (
+	"github.com/sirupsen/logrus"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+	"toy-runc/internal/cgroups"
+)
+
+const oomWatchInterval = time.Second
+
+// StartOOMWatcher spawns a detached `oom-watch` process, the run process of
+// a detached container exits right away and can not watch it itself.
+func StartOOMWatcher(containerName string) error {
+	cmd := exec.Command("/proc/self/exe", "oom-watch", containerName)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// WatchContainerOOM records every oom kill of the container into its
+// config.json until the init process of the container is gone, the
+// container is then marked as exited.
+func WatchContainerOOM(containerName string) {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		logrus.Errorf("get container %s info error; %v", containerName, err)
+		return
+	}
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		logrus.Errorf("conver pid from string to int error; %v", err)
+		return
+	}
+
+	// still notice the container exiting without cgroup or oom events.
+	var events <-chan uint64
+	if containerInfo.CgroupPath != "" {
+		events, err = cgroups.NewCgroupManager(containerInfo.CgroupPath).NotifyOOM()
+		if err != nil {
+			logrus.Errorf("watch container %s oom error; %v", containerName, err)
+		}
+	}
+	ticker := time.NewTicker(oomWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case count, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			logrus.Warnf("container %s oom killed a task, %d kills so far", containerName, count)
+			recordOOMKill(containerName, count)
+		case <-ticker.C:
+			if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+				markContainerExited(containerName, containerInfo.Pid)
+				return
+			}
+		}
+	}
+}
+
+func recordOOMKill(containerName string, count uint64) {
+	unlock, err := lockContainerInfo(containerName)
+	if err != nil {
+		logrus.Errorf("lock container %s info error; %v", containerName, err)
+		return
+	}
+	defer unlock()
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		logrus.Errorf("get container %s info error; %v", containerName, err)
+		return
+	}
+	containerInfo.OOMKilled = true
+	if count > containerInfo.OOMKillCount {
+		containerInfo.OOMKillCount = count
+	}
+	if err := writeContainerInfo(containerInfo); err != nil {
+		logrus.Errorf("write container %s info error; %v", containerName, err)
+	}
+}
+
+// markContainerExited only touches a container that still runs pid, `stop`
+// may have changed it in the meantime.
+func markContainerExited(containerName, pid string) {
+	unlock, err := lockContainerInfo(containerName)
+	if err != nil {
+		return
+	}
+	defer unlock()
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return
+	}
+	if containerInfo.Pid != pid || (containerInfo.Status != RUNNING && containerInfo.Status != PAUSED) {
+		return
+	}
+	containerInfo.Status = Exit
+	containerInfo.Pid = " "
+	if err := writeContainerInfo(containerInfo); err != nil {
+		logrus.Errorf("write container %s info error; %v", containerName, err)
+	}
+}
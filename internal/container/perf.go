@@ -0,0 +1,112 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"toy-runc/internal/cgroups"
+)
+
+// perfExitCheckInterval is how often a perf count checks that the container
+// still runs, so the counters stop with it.
+const perfExitCheckInterval = time.Second
+
+// PerfContainer counts perf events of every task of a container and prints
+// the totals. It counts for duration, or until interrupted when duration
+// is zero, and stops early once the container exits.
+func PerfContainer(containerName string, events []string, duration time.Duration, jsonOutput bool) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error; %v", containerName, err)
+	}
+	if containerInfo.Status != RUNNING && containerInfo.Status != PAUSED {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return fmt.Errorf("conver pid from string to int error; %v", err)
+	}
+
+	cgroupManager, err := containerCgroup(containerInfo)
+	if err != nil {
+		return err
+	}
+	counters, err := cgroupManager.OpenPerfCounters(events)
+	if err != nil {
+		return fmt.Errorf("open container %s perf counters error; %v", containerName, err)
+	}
+	defer counters.Close()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+	var deadline <-chan time.Time
+	if duration > 0 {
+		deadline = time.After(duration)
+	}
+	ticker := time.NewTicker(perfExitCheckInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	if err := counters.Enable(); err != nil {
+		return err
+	}
+wait:
+	for {
+		select {
+		case <-deadline:
+			break wait
+		case <-interrupt:
+			break wait
+		case <-ticker.C:
+			if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+				logrus.Infof("container %s exited, stop counting", containerName)
+				break wait
+			}
+		}
+	}
+	elapsed := time.Since(start)
+
+	counts, err := counters.Read()
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		jsonBytes, err := json.Marshal(counts)
+		if err != nil {
+			return fmt.Errorf("json marshal perf counts error; %v", err)
+		}
+		fmt.Fprintln(os.Stdout, string(jsonBytes))
+		return nil
+	}
+	printPerfTable(containerName, counts, elapsed)
+	return nil
+}
+
+func printPerfTable(containerName string, counts []*cgroups.PerfCount, elapsed time.Duration) {
+	fmt.Fprintf(os.Stdout, "Performance counter stats for container %s:\n\n", containerName)
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "EVENT\tCOUNT\tRUNNING\n")
+	for _, count := range counts {
+		value := strconv.FormatUint(count.Value, 10)
+		// the clock events count nanoseconds.
+		if count.Event == "task-clock" || count.Event == "cpu-clock" {
+			value = fmt.Sprintf("%.2f msec", float64(count.Value)/float64(time.Millisecond))
+		}
+		running := "--"
+		if count.Enabled > 0 {
+			running = fmt.Sprintf("%.2f%%", float64(count.Running)/float64(count.Enabled)*100)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", count.Event, value, running)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("flush error %v", err)
+	}
+	fmt.Fprintf(os.Stdout, "\n%.3f seconds time elapsed\n", elapsed.Seconds())
+}
@@ -0,0 +1,145 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+	"toy-runc/internal/cgroups"
+)
+
+// pressureWatchInterval is how often a pressure watch checks that the
+// container still runs, the stall events themselves arrive through poll.
+const pressureWatchInterval = time.Second
+
+// PressureContainer prints the pressure stall information of a container.
+// With triggers it then stays and logs an event each time a stall exceeds
+// the threshold of a trigger, until the container exits.
+func PressureContainer(containerName string, triggers []*cgroups.PressureTrigger, jsonOutput bool) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error; %v", containerName, err)
+	}
+	if containerInfo.Status != RUNNING && containerInfo.Status != PAUSED {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	cgroupManager, err := containerCgroup(containerInfo)
+	if err != nil {
+		return err
+	}
+	pressure, err := cgroupManager.GetPressure()
+	if err != nil {
+		return fmt.Errorf("get container %s pressure error; %v", containerName, err)
+	}
+	if jsonOutput {
+		jsonBytes, err := json.Marshal(pressure)
+		if err != nil {
+			return fmt.Errorf("json marshal pressure error; %v", err)
+		}
+		fmt.Fprintln(os.Stdout, string(jsonBytes))
+	} else {
+		printPressureTable(pressure)
+	}
+	if len(triggers) == 0 {
+		return nil
+	}
+
+	pid, err := strconv.Atoi(containerInfo.Pid)
+	if err != nil {
+		return fmt.Errorf("conver pid from string to int error; %v", err)
+	}
+	type pressureEvent struct {
+		trigger *cgroups.PressureTrigger
+		at      time.Time
+	}
+	events := make(chan pressureEvent)
+	for _, trigger := range triggers {
+		notify, err := cgroupManager.NotifyPressure(trigger)
+		if err != nil {
+			return fmt.Errorf("watch container %s pressure error; %v", containerName, err)
+		}
+		go func(trigger *cgroups.PressureTrigger, notify <-chan struct{}) {
+			for range notify {
+				events <- pressureEvent{trigger: trigger, at: time.Now()}
+			}
+		}(trigger, notify)
+	}
+
+	ticker := time.NewTicker(pressureWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-events:
+			trigger := event.trigger
+			var avg10 float64
+			if pressure, err := cgroupManager.GetPressure(); err == nil {
+				if psi := pressure.Get(trigger.Resource); psi != nil {
+					avg10 = psi.Some.Avg10
+					if trigger.Type == "full" {
+						avg10 = psi.Full.Avg10
+					}
+				}
+			}
+			logrus.Warnf("container %s %s pressure: %s stall exceeded %s within %s at %s, avg10 %.2f%%",
+				containerName, trigger.Resource, trigger.Type, trigger.Threshold, trigger.Window,
+				event.at.Format("2006-01-02 15:04:05"), avg10)
+		case <-ticker.C:
+			if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+				logrus.Infof("container %s exited, stop watching pressure", containerName)
+				return nil
+			}
+		}
+	}
+}
+
+func printPressureTable(pressure *cgroups.PressureStats) {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "RESOURCE\tTYPE\tAVG10\tAVG60\tAVG300\tTOTAL\n")
+	for _, resource := range cgroups.PressureResources {
+		psi := pressure.Get(resource)
+		if psi == nil {
+			continue
+		}
+		lines := []struct {
+			name string
+			data cgroups.PSIData
+		}{
+			{"some", psi.Some},
+			{"full", psi.Full},
+		}
+		for _, line := range lines {
+			fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%.2f%%\t%.2f%%\t%s\n",
+				resource,
+				line.name,
+				line.data.Avg10,
+				line.data.Avg60,
+				line.data.Avg300,
+				time.Duration(line.data.Total)*time.Microsecond,
+			)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("flush error %v", err)
+	}
+}
+
+// formatPressure prints the some avg10 of cpu, memory and io for the stats
+// table, "--" where the host has no pressure information.
+func formatPressure(pressure *cgroups.PressureStats) string {
+	if pressure == nil {
+		return "--"
+	}
+	var values []interface{}
+	for _, resource := range cgroups.PressureResources {
+		var avg10 float64
+		if psi := pressure.Get(resource); psi != nil {
+			avg10 = psi.Some.Avg10
+		}
+		values = append(values, avg10)
+	}
+	return fmt.Sprintf("%.2f / %.2f / %.2f", values...)
+}
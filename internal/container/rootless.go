@@ -0,0 +1,76 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path"
+)
+
+const ENV_INIT_MOUNTS = "myrunc_mounts"
+
+// Rootless is set when the runtime runs as an unprivileged user. Containers
+// then get a user namespace mapping the user to root, state and images live
+// in the directories of the user, and cgroups and networks are best effort.
+var Rootless = os.Geteuid() != 0
+
+// SetupRootless moves the state under $XDG_RUNTIME_DIR and the images and
+// layers under $XDG_DATA_HOME, ~/.local/share by default.
+func SetupRootless() error {
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/tmp/myrunc-%d", os.Geteuid())
+		logrus.Warnf("XDG_RUNTIME_DIR is not set, keep the state in %s", runtimeDir)
+	}
+	dataDir := os.Getenv("XDG_DATA_HOME")
+	if dataDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("find home directory error; %v", err)
+		}
+		dataDir = path.Join(home, ".local", "share")
+	}
+
+	DefaultInfoLocation = path.Join(runtimeDir, "myRunc") + "/%s/"
+	RootUrl = path.Join(dataDir, "toy-runc")
+	LayerUrl = RootUrl
+	MntUrl = RootUrl + "/mnt/%s"
+	WriteLayerUrl = RootUrl + "/writeLayer/%s"
+	return nil
+}
+
+// RootlessIDMappings maps the user and its group to root of the container,
+// an unprivileged process may only map its own ids.
+func RootlessIDMappings() *IDMappings {
+	return &IDMappings{
+		UidMappings: []IDMap{{ContainerID: 0, HostID: os.Geteuid(), Size: 1}},
+		GidMappings: []IDMap{{ContainerID: 0, HostID: os.Getegid(), Size: 1}},
+	}
+}
+
+// rootfsMount is a mount of the workspace the parent can not make without
+// root, init makes it inside its user namespace before the pivot.
+type rootfsMount struct {
+	Source string  `json:"source"`
+	Target string  `json:"target"`
+	Fstype string  `json:"fstype"`
+	Flags  uintptr `json:"flags"`
+	Data   string  `json:"data"`
+}
+
+// readInitMounts reads the workspace mounts handed over by the parent and
+// drops the variable so it does not leak into the user process.
+func readInitMounts() []*rootfsMount {
+	content := os.Getenv(ENV_INIT_MOUNTS)
+	os.Unsetenv(ENV_INIT_MOUNTS)
+	if content == "" {
+		return nil
+	}
+	var mounts []*rootfsMount
+	if err := json.Unmarshal([]byte(content), &mounts); err != nil {
+		logrus.Errorf("unmarshal init mounts error; %v", err)
+		return nil
+	}
+	return mounts
+}
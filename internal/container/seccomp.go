@@ -0,0 +1,90 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"strings"
+	"toy-runc/internal/seccomp"
+)
+
+const (
+	ENV_INIT_SECCOMP = "myrunc_seccomp"
+	// ENV_EXEC_SECCOMP hands the compiled filter of the container to exec.
+	ENV_EXEC_SECCOMP = "myrunc_seccomp_filter"
+
+	SeccompUnconfined = "unconfined"
+	// seccompProfileName keeps the profile next to the container info, the
+	// profile file of the seccomp option may change or be gone on exec.
+	seccompProfileName = "seccomp.json"
+)
+
+// readInitSeccomp reads the profile handed over by the parent, nil when
+// the container is unconfined.
+func readInitSeccomp() (*seccomp.Profile, error) {
+	content := os.Getenv(ENV_INIT_SECCOMP)
+	os.Unsetenv(ENV_INIT_SECCOMP)
+	if content == "" {
+		return nil, nil
+	}
+	profile := &seccomp.Profile{}
+	if err := json.Unmarshal([]byte(content), profile); err != nil {
+		return nil, fmt.Errorf("unmarshal seccomp profile error; %v", err)
+	}
+	return profile, nil
+}
+
+// installSeccomp compiles the profile for the capabilities of the
+// container and installs it.
+func installSeccomp(profile *seccomp.Profile, caps []string) error {
+	program, err := seccomp.Compile(profile, caps)
+	if err != nil {
+		return fmt.Errorf("compile seccomp profile error; %v", err)
+	}
+	logrus.Infof("install seccomp filter of %d instructions", len(program.Instructions))
+	return seccomp.Install(program)
+}
+
+// recordSeccompProfile keeps the profile of a container for exec.
+func recordSeccompProfile(containerName string, profile *seccomp.Profile) error {
+	content, err := json.Marshal(profile)
+	if err != nil {
+		return fmt.Errorf("marshal seccomp profile error; %v", err)
+	}
+	file := fmt.Sprintf(DefaultInfoLocation, containerName) + seccompProfileName
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		return fmt.Errorf("write seccomp profile %s error; %v", file, err)
+	}
+	return nil
+}
+
+// execSeccompFilter compiles the recorded profile of a container for
+// exec, empty when the container is unconfined. The filter is the flags
+// and each instruction as code, jt, jf and k in hexadecimal, e.g.
+// "0:0020000000000004..." for nsenter to parse before the go runtime runs.
+func execSeccompFilter(containerInfo *ContainerInfo) (string, error) {
+	if containerInfo.Security == nil || containerInfo.Security.Seccomp == SeccompUnconfined {
+		return "", nil
+	}
+	file := fmt.Sprintf(DefaultInfoLocation, containerInfo.Name) + seccompProfileName
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("read seccomp profile %s error; %v", file, err)
+	}
+	profile := &seccomp.Profile{}
+	if err := json.Unmarshal(content, profile); err != nil {
+		return "", fmt.Errorf("unmarshal seccomp profile %s error; %v", file, err)
+	}
+	program, err := seccomp.Compile(profile, containerInfo.Capabilities)
+	if err != nil {
+		return "", fmt.Errorf("compile seccomp profile error; %v", err)
+	}
+	var filter strings.Builder
+	fmt.Fprintf(&filter, "%x:", program.Flags)
+	for _, insn := range program.Instructions {
+		fmt.Fprintf(&filter, "%04x%02x%02x%08x", insn.Code, insn.Jt, insn.Jf, insn.K)
+	}
+	return filter.String(), nil
+}
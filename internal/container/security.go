@@ -0,0 +1,134 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"toy-runc/internal/seccomp"
+)
+
+const (
+	ENV_INIT_SECURITY = "myrunc_security"
+	// ENV_EXEC_NO_NEW_PRIVS makes exec set no_new_privs before the command.
+	ENV_EXEC_NO_NEW_PRIVS = "myrunc_no_new_privs"
+
+	SecurityOptNoNewPrivileges = "no-new-privileges"
+	SecurityOptSeccomp         = "seccomp"
+)
+
+// SecurityConfig holds the --security-opt options of a container.
+type SecurityConfig struct {
+	// NoNewPrivileges keeps setuid binaries and file capabilities from
+	// granting privileges to the container and its exec'd processes.
+	NoNewPrivileges bool `json:"noNewPrivileges"`
+	// Seccomp is the seccomp option, empty for the built-in profile.
+	Seccomp string `json:"seccomp,omitempty"`
+	// SeccompProfile is the profile Seccomp selects, nil when unconfined.
+	// The state only keeps the option, init gets the profile on its own.
+	SeccompProfile *seccomp.Profile `json:"-"`
+}
+
+// ParseSecurityOpts reads the --security-opt options of run,
+// no-new-privileges[=true|false] sets no_new_privs in the container,
+// seccomp=<profile.json> filters with a docker or OCI profile and
+// seccomp=unconfined turns filtering off. Like docker a colon may separate
+// key and value. Without a seccomp option the container gets the default
+// profile.
+func ParseSecurityOpts(opts []string) (*SecurityConfig, error) {
+	config := &SecurityConfig{SeccompProfile: seccomp.DefaultProfile()}
+	for _, opt := range opts {
+		key, value := splitSecurityOpt(opt)
+		switch key {
+		case SecurityOptNoNewPrivileges:
+			noNewPrivs, err := parseSecurityBool(opt, value)
+			if err != nil {
+				return nil, err
+			}
+			config.NoNewPrivileges = noNewPrivs
+		case SecurityOptSeccomp:
+			if value == "" {
+				return nil, fmt.Errorf("invalid security option %s, must be seccomp=<profile.json> or seccomp=%s", opt, SeccompUnconfined)
+			}
+			config.Seccomp = value
+			if value == SeccompUnconfined {
+				config.SeccompProfile = nil
+				continue
+			}
+			profile, err := seccomp.LoadProfile(value)
+			if err != nil {
+				return nil, err
+			}
+			config.SeccompProfile = profile
+		default:
+			return nil, fmt.Errorf("unknown security option %s", key)
+		}
+	}
+	return config, nil
+}
+
+// ParseExecSecurityOpts reads the --security-opt options of exec, only
+// no-new-privileges applies to a process joining a running container.
+func ParseExecSecurityOpts(opts []string) (*SecurityConfig, error) {
+	config := &SecurityConfig{}
+	for _, opt := range opts {
+		key, value := splitSecurityOpt(opt)
+		switch key {
+		case SecurityOptNoNewPrivileges:
+			noNewPrivs, err := parseSecurityBool(opt, value)
+			if err != nil {
+				return nil, err
+			}
+			config.NoNewPrivileges = noNewPrivs
+		case SecurityOptSeccomp:
+			return nil, fmt.Errorf("security option %s is fixed when the container runs", key)
+		default:
+			return nil, fmt.Errorf("unknown security option %s", key)
+		}
+	}
+	return config, nil
+}
+
+func splitSecurityOpt(opt string) (string, string) {
+	sep := "="
+	if !strings.Contains(opt, "=") {
+		sep = ":"
+	}
+	parts := strings.SplitN(opt, sep, 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// parseSecurityBool reads the value of a flag option, no value means true.
+func parseSecurityBool(opt, value string) (bool, error) {
+	if value == "" {
+		return true, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid security option %s, must be true or false", opt)
+	}
+	return b, nil
+}
+
+// readInitSecurity reads the security config handed over by the parent,
+// together with the seccomp profile.
+func readInitSecurity() (*SecurityConfig, error) {
+	content := os.Getenv(ENV_INIT_SECURITY)
+	os.Unsetenv(ENV_INIT_SECURITY)
+	config := &SecurityConfig{}
+	if content != "" {
+		if err := json.Unmarshal([]byte(content), config); err != nil {
+			return nil, fmt.Errorf("unmarshal security config error; %v", err)
+		}
+	}
+	profile, err := readInitSeccomp()
+	if err != nil {
+		return nil, err
+	}
+	config.SeccompProfile = profile
+	return config, nil
+}
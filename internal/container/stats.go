@@ -0,0 +1,181 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"text/tabwriter"
+	"time"
+	"toy-runc/internal/cgroups"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+// ContainerStats is one line of the stats output, CpuPercent is relative to
+// a single cpu so a container using two full cores shows 200%.
+type ContainerStats struct {
+	Id            string  `json:"id"`
+	Name          string  `json:"name"`
+	CpuPercent    float64 `json:"cpuPercent"`
+	MemoryUsage   uint64  `json:"memoryUsage"`
+	MemoryLimit   uint64  `json:"memoryLimit"`
+	MemoryPercent float64 `json:"memoryPercent"`
+	Pids          uint64  `json:"pids"`
+	BlockRead     uint64  `json:"blockRead"`
+	BlockWrite    uint64  `json:"blockWrite"`
+
+	Pressure *cgroups.PressureStats `json:"pressure,omitempty"`
+}
+
+type statsSample struct {
+	info  *ContainerInfo
+	stats *cgroups.Stats
+	at    time.Time
+}
+
+// StatsContainer prints the resource usage of the named containers, or of
+// every running container when no name is given. The cpu usage needs two
+// samples so the first output comes after one interval. The rows keep the
+// order of the names, or of the container names, across refreshes.
+func StatsContainer(containerNames []string, stream bool, interval time.Duration, jsonOutput bool) {
+	previous := samplesById(sampleContainers(containerNames))
+	for {
+		time.Sleep(interval)
+		current := sampleContainers(containerNames)
+
+		var entries []*ContainerStats
+		for _, sample := range current {
+			entries = append(entries, newContainerStats(previous[sample.info.Id], sample))
+		}
+		if jsonOutput {
+			printStatsJson(entries)
+		} else {
+			if stream {
+				// clear the screen and move the cursor home, the same way `top` redraws.
+				fmt.Fprint(os.Stdout, "\033[2J\033[H")
+			}
+			printStatsTable(entries)
+		}
+
+		if !stream {
+			return
+		}
+		previous = samplesById(current)
+	}
+}
+
+func samplesById(samples []*statsSample) map[string]*statsSample {
+	byId := map[string]*statsSample{}
+	for _, sample := range samples {
+		byId[sample.info.Id] = sample
+	}
+	return byId
+}
+
+// sampleContainers reads the stats of the containers in the order of
+// containerNames, or sorted by container name when no name is given.
+func sampleContainers(containerNames []string) []*statsSample {
+	var infos []*ContainerInfo
+	if len(containerNames) == 0 {
+		containers, err := listContainerInfos()
+		if err != nil {
+			return nil
+		}
+		for _, info := range containers {
+			if info.Status == RUNNING || info.Status == PAUSED {
+				infos = append(infos, info)
+			}
+		}
+	} else {
+		for _, containerName := range containerNames {
+			info, err := getContainerInfoByName(containerName)
+			if err != nil {
+				logrus.Errorf("get container %s info error; %v", containerName, err)
+				continue
+			}
+			infos = append(infos, info)
+		}
+	}
+
+	var samples []*statsSample
+	for _, info := range infos {
+		cgroupManager, err := containerCgroup(info)
+		if err != nil {
+			logrus.Errorf("get container %s stats error; %v", info.Name, err)
+			continue
+		}
+		stats, err := cgroupManager.GetStats()
+		if err != nil {
+			logrus.Errorf("get container %s stats error; %v", info.Name, err)
+			continue
+		}
+		samples = append(samples, &statsSample{
+			info:  info,
+			stats: stats,
+			at:    time.Now(),
+		})
+	}
+	return samples
+}
+
+func newContainerStats(previous, current *statsSample) *ContainerStats {
+	stats := current.stats
+	entry := &ContainerStats{
+		Id:          current.info.Id,
+		Name:        current.info.Name,
+		MemoryUsage: stats.Memory.Usage,
+		MemoryLimit: stats.Memory.Limit,
+		Pids:        stats.Pids.Current,
+		BlockRead:   stats.Blkio.Read,
+		BlockWrite:  stats.Blkio.Write,
+		Pressure:    stats.Pressure,
+	}
+	if previous != nil && stats.Cpu.Usage >= previous.stats.Cpu.Usage {
+		elapsed := current.at.Sub(previous.at).Nanoseconds()
+		if elapsed > 0 {
+			entry.CpuPercent = float64(stats.Cpu.Usage-previous.stats.Cpu.Usage) / float64(elapsed) * 100
+		}
+	}
+	if entry.MemoryLimit == 0 {
+		if total, err := subsystems.HostMemoryTotal(); err == nil {
+			entry.MemoryLimit = total
+		}
+	}
+	if entry.MemoryLimit > 0 {
+		entry.MemoryPercent = float64(entry.MemoryUsage) / float64(entry.MemoryLimit) * 100
+	}
+	return entry
+}
+
+func printStatsTable(entries []*ContainerStats) {
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tPIDS\tBLOCK I/O\tPSI CPU / MEM / IO\n")
+	for _, item := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%d\t%s / %s\t%s\n",
+			item.Id,
+			item.Name,
+			item.CpuPercent,
+			subsystems.FormatSize(item.MemoryUsage),
+			subsystems.FormatSize(item.MemoryLimit),
+			item.MemoryPercent,
+			item.Pids,
+			subsystems.FormatSize(item.BlockRead),
+			subsystems.FormatSize(item.BlockWrite),
+			formatPressure(item.Pressure),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("flush error %v", err)
+	}
+}
+
+func printStatsJson(entries []*ContainerStats) {
+	for _, item := range entries {
+		jsonBytes, err := json.Marshal(item)
+		if err != nil {
+			logrus.Errorf("json marshal stats error; %v", err)
+			continue
+		}
+		fmt.Fprintln(os.Stdout, string(jsonBytes))
+	}
+}
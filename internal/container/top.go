@@ -0,0 +1,236 @@
+package container
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+// clockTicks is USER_HZ, the unit of the cpu times in /proc/<pid>/stat, it
+// is 100 on every architecture linux supports.
+const clockTicks = 100
+
+// Process is a process of a container as seen from the host.
+type Process struct {
+	Pid int
+	// NsPid is the pid inside the pid namespace of the container.
+	NsPid   int
+	Ppid    int
+	User    string
+	CpuTime time.Duration
+	// Rss is the resident set size in bytes.
+	Rss     uint64
+	Command string
+}
+
+// TopContainer prints the processes of a container with their pid on the
+// host and inside the container. Without psArgs the table is built from
+// /proc, otherwise the output of `ps psArgs` is filtered to the container
+// and prefixed with the pid inside the container.
+func TopContainer(containerName string, psArgs []string) error {
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error; %v", containerName, err)
+	}
+	if containerInfo.Status != RUNNING && containerInfo.Status != PAUSED {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+	cgroupManager, err := containerCgroup(containerInfo)
+	if err != nil {
+		return err
+	}
+	pids, err := cgroupManager.GetPids()
+	if err != nil {
+		return fmt.Errorf("get container %s pids error; %v", containerName, err)
+	}
+	sort.Ints(pids)
+	if len(psArgs) > 0 {
+		return printPsTable(pids, psArgs)
+	}
+
+	var processes []*Process
+	for _, pid := range pids {
+		process, err := readProcess(pid)
+		if os.IsNotExist(err) {
+			// exited since cgroup.procs was read.
+			continue
+		} else if err != nil {
+			return fmt.Errorf("read process %d error; %v", pid, err)
+		}
+		processes = append(processes, process)
+	}
+	printTopTable(processes)
+	return nil
+}
+
+func printTopTable(processes []*Process) {
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprint(w, "PID\tNSPID\tPPID\tUSER\tTIME\tRSS\tCOMMAND\n")
+	for _, process := range processes {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%s\t%s\t%s\t%s\n",
+			process.Pid,
+			process.NsPid,
+			process.Ppid,
+			process.User,
+			formatCpuTime(process.CpuTime),
+			subsystems.FormatSize(process.Rss),
+			process.Command,
+		)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("flush error %v", err)
+	}
+}
+
+// printPsTable runs ps on the host and keeps the header and the lines whose
+// PID column belongs to the container.
+func printPsTable(pids []int, psArgs []string) error {
+	output, err := exec.Command("ps", psArgs...).Output()
+	if err != nil {
+		return fmt.Errorf("run ps %s error; %v", strings.Join(psArgs, " "), err)
+	}
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	pidColumn := -1
+	for i, field := range strings.Fields(lines[0]) {
+		if field == "PID" {
+			pidColumn = i
+			break
+		}
+	}
+	if pidColumn < 0 {
+		return fmt.Errorf("ps %s does not print a PID column", strings.Join(psArgs, " "))
+	}
+	inContainer := map[int]bool{}
+	for _, pid := range pids {
+		inContainer[pid] = true
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 8, 1, 3, ' ', 0)
+	fmt.Fprintf(w, "NSPID\t%s\n", lines[0])
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) <= pidColumn {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[pidColumn])
+		if err != nil || !inContainer[pid] {
+			continue
+		}
+		nsPid := "-"
+		if status, err := readStatus(pid); err == nil {
+			nsPid = strconv.Itoa(nsPidOf(status, pid))
+		}
+		fmt.Fprintf(w, "%s\t%s\n", nsPid, line)
+	}
+	if err := w.Flush(); err != nil {
+		logrus.Errorf("flush error %v", err)
+	}
+	return nil
+}
+
+func readProcess(pid int) (*Process, error) {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// the command name in parentheses may contain spaces and parentheses,
+	// the fields after it start with the state, see proc(5).
+	content := string(stat)
+	end := strings.LastIndex(content, ")")
+	start := strings.Index(content, "(")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	name := content[start+1 : end]
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid /proc/%d/stat", pid)
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	utime, _ := strconv.ParseUint(fields[11], 10, 64)
+	stime, _ := strconv.ParseUint(fields[12], 10, 64)
+	rssPages, _ := strconv.ParseUint(fields[21], 10, 64)
+
+	status, err := readStatus(pid)
+	if err != nil {
+		return nil, err
+	}
+	process := &Process{
+		Pid:     pid,
+		NsPid:   nsPidOf(status, pid),
+		Ppid:    ppid,
+		User:    userOf(status),
+		CpuTime: time.Duration(utime+stime) * time.Second / clockTicks,
+		Rss:     rssPages * uint64(os.Getpagesize()),
+		Command: "[" + name + "]",
+	}
+	if cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid)); err == nil && len(cmdline) > 0 {
+		// kernel threads have an empty cmdline and keep the bracketed name.
+		process.Command = strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+	}
+	return process, nil
+}
+
+// readStatus reads the "Key:\tvalue" lines of /proc/<pid>/status.
+func readStatus(pid int) (map[string]string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	status := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 {
+			status[parts[0]] = strings.TrimSpace(parts[1])
+		}
+	}
+	return status, nil
+}
+
+// nsPidOf picks the innermost pid of the NSpid line, which lists the pid in
+// every pid namespace from the one of /proc down to the one of the process.
+// Kernels before 4.1 have no NSpid line, the host pid is the best guess.
+func nsPidOf(status map[string]string, pid int) int {
+	fields := strings.Fields(status["NSpid"])
+	if len(fields) == 0 {
+		return pid
+	}
+	nsPid, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil {
+		return pid
+	}
+	return nsPid
+}
+
+// userOf resolves the real uid of the Uid line, a uid without a user on the
+// host is printed as is.
+func userOf(status map[string]string) string {
+	fields := strings.Fields(status["Uid"])
+	if len(fields) == 0 {
+		return "?"
+	}
+	if u, err := user.LookupId(fields[0]); err == nil {
+		return u.Username
+	}
+	return fields[0]
+}
+
+// formatCpuTime prints a cpu time the way ps does, [dd-]hh:mm:ss.
+func formatCpuTime(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	days := seconds / 86400
+	clock := fmt.Sprintf("%02d:%02d:%02d", seconds/3600%24, seconds/60%60, seconds%60)
+	if days > 0 {
+		return fmt.Sprintf("%d-%s", days, clock)
+	}
+	return clock
+}
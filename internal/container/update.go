@@ -0,0 +1,71 @@
+package container
+
+import (
+	"fmt"
+	"toy-runc/internal/cgroups/subsystems"
+)
+
+// UpdateContainer changes the resource limits of a running container, update
+// receives the current limits and changes the ones to update in place. Only
+// the controllers of the changed limits are written to the cgroup, the whole
+// set is persisted in config.json.
+func UpdateContainer(containerName string, update func(res *subsystems.ResourceConfig) error) error {
+	unlock, err := lockContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	containerInfo, err := getContainerInfoByName(containerName)
+	if err != nil {
+		return fmt.Errorf("get container %s info error; %v", containerName, err)
+	}
+	if containerInfo.Status != RUNNING && containerInfo.Status != PAUSED {
+		return fmt.Errorf("container %s is not running", containerName)
+	}
+
+	// update runs on an empty config first to tell which limits it changes.
+	changes := subsystems.ResourceConfig{}
+	if err := update(&changes); err != nil {
+		return err
+	}
+	controllers := changes.Controllers()
+	if len(controllers) == 0 {
+		return fmt.Errorf("no resource limit to update")
+	}
+
+	res := subsystems.ResourceConfig{}
+	if containerInfo.Resources != nil {
+		res = *containerInfo.Resources
+	}
+	if err := update(&res); err != nil {
+		return err
+	}
+	if err := res.Validate(); err != nil {
+		return err
+	}
+
+	cgroupManager, err := containerCgroup(containerInfo)
+	if err != nil {
+		return err
+	}
+	if changes.MemoryLimit > 0 {
+		// v1 refuses such a limit with EBUSY while v2 oom kills to reach it.
+		stats, err := cgroupManager.GetStats()
+		if err != nil {
+			return fmt.Errorf("get container %s stats error; %v", containerName, err)
+		}
+		if uint64(res.MemoryLimit) < stats.Memory.Usage {
+			return fmt.Errorf("memory limit %s is below the current usage %s of container %s",
+				subsystems.FormatSize(uint64(res.MemoryLimit)), subsystems.FormatSize(stats.Memory.Usage), containerName)
+		}
+	}
+	if err := cgroupManager.Update(&res, controllers); err != nil {
+		return fmt.Errorf("update container %s cgroup error; %v", containerName, err)
+	}
+
+	containerInfo.Resources = &res
+	if err := writeContainerInfo(containerInfo); err != nil {
+		return fmt.Errorf("write container %s info error; %v", containerName, err)
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package container
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	subuidFile = "/etc/subuid"
+	subgidFile = "/etc/subgid"
+)
+
+// IDMap maps Size ids starting at ContainerID inside the container to the
+// ids starting at HostID on the host.
+type IDMap struct {
+	ContainerID int `json:"containerId"`
+	HostID      int `json:"hostId"`
+	Size        int `json:"size"`
+}
+
+// IDMappings are the uid and gid mappings of the user namespace of a container.
+type IDMappings struct {
+	UidMappings []IDMap `json:"uidMappings"`
+	GidMappings []IDMap `json:"gidMappings"`
+}
+
+// ParseIDMap parses "<container id>:<host id>:<size>", e.g. 0:100000:65536.
+func ParseIDMap(spec string) (IDMap, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) != 3 {
+		return IDMap{}, fmt.Errorf("invalid id mapping %s, must be <container id>:<host id>:<size>", spec)
+	}
+	var values [3]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return IDMap{}, fmt.Errorf("invalid id mapping %s", spec)
+		}
+		values[i] = value
+	}
+	if values[2] == 0 {
+		return IDMap{}, fmt.Errorf("invalid id mapping %s, size must be positive", spec)
+	}
+	return IDMap{ContainerID: values[0], HostID: values[1], Size: values[2]}, nil
+}
+
+// NewIDMappings checks that the mappings give the container a root, without
+// uid and gid 0 init could not set up the container. Gid mappings default
+// to the uid mappings.
+func NewIDMappings(uidMaps, gidMaps []IDMap) (*IDMappings, error) {
+	if len(gidMaps) == 0 {
+		gidMaps = uidMaps
+	}
+	mappings := &IDMappings{UidMappings: uidMaps, GidMappings: gidMaps}
+	if _, ok := hostID(uidMaps, 0); !ok {
+		return nil, fmt.Errorf("uid mappings must map root of the container")
+	}
+	if _, ok := hostID(gidMaps, 0); !ok {
+		return nil, fmt.Errorf("gid mappings must map root of the container")
+	}
+	return mappings, nil
+}
+
+// RemapIDMappings builds the mappings for --userns-remap "user[:group]" from
+// the subordinate id ranges of /etc/subuid and /etc/subgid. The group
+// defaults to the user, ranges are stacked from container id 0 on.
+func RemapIDMappings(remap string) (*IDMappings, error) {
+	parts := strings.SplitN(remap, ":", 2)
+	userName, groupName := parts[0], parts[0]
+	if len(parts) == 2 {
+		groupName = parts[1]
+	}
+	if userName == "" || groupName == "" {
+		return nil, fmt.Errorf("invalid userns-remap %s, must be user[:group]", remap)
+	}
+
+	// the files may name the user or hold its numeric id.
+	userKeys := []string{userName}
+	if u, err := user.Lookup(userName); err == nil {
+		userKeys = append(userKeys, u.Uid)
+	} else if u, err := user.LookupId(userName); err == nil {
+		userKeys = append(userKeys, u.Username)
+	}
+	groupKeys := []string{groupName}
+	if g, err := user.LookupGroup(groupName); err == nil {
+		groupKeys = append(groupKeys, g.Gid)
+	} else if g, err := user.LookupGroupId(groupName); err == nil {
+		groupKeys = append(groupKeys, g.Name)
+	}
+
+	uidMaps, err := readSubIDs(subuidFile, userKeys)
+	if err != nil {
+		return nil, err
+	}
+	if len(uidMaps) == 0 {
+		return nil, fmt.Errorf("no subordinate uids for %s in %s", userName, subuidFile)
+	}
+	gidMaps, err := readSubIDs(subgidFile, groupKeys)
+	if err != nil {
+		return nil, err
+	}
+	if len(gidMaps) == 0 {
+		return nil, fmt.Errorf("no subordinate gids for %s in %s", groupName, subgidFile)
+	}
+	return NewIDMappings(uidMaps, gidMaps)
+}
+
+// readSubIDs reads the "<name or id>:<start>:<count>" ranges of keys.
+func readSubIDs(file string, keys []string) ([]IDMap, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open %s error; %v", file, err)
+	}
+	defer f.Close()
+
+	var maps []IDMap
+	containerID := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Split(strings.TrimSpace(scanner.Text()), ":")
+		if len(parts) != 3 || !containsString(keys, parts[0]) {
+			continue
+		}
+		start, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %s in %s", scanner.Text(), file)
+		}
+		count, err := strconv.Atoi(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %s in %s", scanner.Text(), file)
+		}
+		maps = append(maps, IDMap{ContainerID: containerID, HostID: start, Size: count})
+		containerID += count
+	}
+	return maps, scanner.Err()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RootUid is the host uid of root in the container.
+func (m *IDMappings) RootUid() int {
+	uid, _ := hostID(m.UidMappings, 0)
+	return uid
+}
+
+// RootGid is the host gid of root in the container.
+func (m *IDMappings) RootGid() int {
+	gid, _ := hostID(m.GidMappings, 0)
+	return gid
+}
+
+// Key tells apart the mappings of shifted layers, it starts with the host
+// ids of root to keep the layer directories readable.
+func (m *IDMappings) Key() string {
+	hash := sha256.New()
+	for _, maps := range [][]IDMap{m.UidMappings, m.GidMappings} {
+		for _, idMap := range maps {
+			fmt.Fprintf(hash, "%d:%d:%d,", idMap.ContainerID, idMap.HostID, idMap.Size)
+		}
+		hash.Write([]byte("/"))
+	}
+	return fmt.Sprintf("%d.%d.%x", m.RootUid(), m.RootGid(), hash.Sum(nil)[:6])
+}
+
+func hostID(maps []IDMap, containerID int) (int, bool) {
+	for _, m := range maps {
+		if containerID >= m.ContainerID && containerID < m.ContainerID+m.Size {
+			return m.HostID + containerID - m.ContainerID, true
+		}
+	}
+	return 0, false
+}
+
+func sysProcIDMaps(maps []IDMap) []syscall.SysProcIDMap {
+	var result []syscall.SysProcIDMap
+	for _, m := range maps {
+		result = append(result, syscall.SysProcIDMap{ContainerID: m.ContainerID, HostID: m.HostID, Size: m.Size})
+	}
+	return result
+}
+
+// shiftOwnership moves the owner of every file below dir from its container
+// id to the host id of the mappings, so files owned by root of the image
+// are owned by root of the container. Ids outside the mappings are kept,
+// they show up as nobody in the container.
+func shiftOwnership(dir string, mappings *IDMappings) error {
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			return nil
+		}
+		uid, uidOk := hostID(mappings.UidMappings, int(stat.Uid))
+		gid, gidOk := hostID(mappings.GidMappings, int(stat.Gid))
+		if !uidOk {
+			uid = int(stat.Uid)
+		}
+		if !gidOk {
+			gid = int(stat.Gid)
+		}
+		if err := os.Lchown(file, uid, gid); err != nil {
+			return fmt.Errorf("chown %s error; %v", file, err)
+		}
+		// chown clears the setuid and setgid bits.
+		if info.Mode()&os.ModeSymlink == 0 && stat.Mode&(syscall.S_ISUID|syscall.S_ISGID) != 0 {
+			if err := syscall.Chmod(file, stat.Mode&07777); err != nil {
+				return fmt.Errorf("chmod %s error; %v", file, err)
+			}
+		}
+		return nil
+	})
+}
+
+// RemapRoot is the data root of the containers whose root is the host ids
+// of root in idMappings, like docker keeps /var/lib/docker/<uid>.<gid>.
+func RemapRoot(idMappings *IDMappings) string {
+	return filepath.Join(RemapRootUrl, fmt.Sprintf("%d.%d", idMappings.RootUid(), idMappings.RootGid()))
+}
+
+// SetupRemapRoot moves the layers and the mount point of a remapped container
+// under its data root. Root of the container has to walk to its rootfs, only
+// the data root is opened for it and host directories keep their modes.
+func SetupRemapRoot(idMappings *IDMappings) error {
+	root := RemapRoot(idMappings)
+	for _, dir := range []string{RemapRootUrl, root} {
+		if err := os.Mkdir(dir, 0711); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("mkdir dir %s error; %v", dir, err)
+		}
+		// the umask may have masked the search bit of others.
+		if err := os.Chmod(dir, 0711); err != nil {
+			return fmt.Errorf("chmod dir %s error; %v", dir, err)
+		}
+	}
+	LayerUrl = root
+	MntUrl = root + "/mnt/%s"
+	WriteLayerUrl = root + "/writeLayer/%s"
+	return nil
+}
+
+// runningInUserNS reports whether the process lives in a user namespace
+// other than the initial one, whose uid_map spans every uid.
+func runningInUserNS() bool {
+	content, err := ioutil.ReadFile("/proc/self/uid_map")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(content))
+	return !(len(fields) == 3 && fields[0] == "0" && fields[1] == "0" && fields[2] == "4294967295")
+}
@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"strings"
 	"time"
+	"toy-runc/internal/cgroups"
 )
 
 func getEnvsByPid(pid string) []string {
@@ -39,6 +41,56 @@ func getContainerInfoByName(containerName string) (*ContainerInfo, error) {
 	return &containerInfo, nil
 }
 
+// lockContainerInfo takes an exclusive flock on the state directory of a
+// container. Every read-modify-write of config.json holds it, the commands
+// and the oom watcher would otherwise overwrite the changes of each other.
+// The returned function releases the lock.
+func lockContainerInfo(containerName string) (func(), error) {
+	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
+	dir, err := os.Open(dirURL)
+	if err != nil {
+		return nil, fmt.Errorf("open dir %s error; %v", dirURL, err)
+	}
+	if err := unix.Flock(int(dir.Fd()), unix.LOCK_EX); err != nil {
+		dir.Close()
+		return nil, fmt.Errorf("lock dir %s error; %v", dirURL, err)
+	}
+	return func() {
+		dir.Close()
+	}, nil
+}
+
+// writeContainerInfo persists containerInfo back into its config.json, the
+// caller holds lockContainerInfo. The file is replaced by a rename so readers
+// never see half of it, and a removed container is not brought back.
+func writeContainerInfo(containerInfo *ContainerInfo) error {
+	newContentBytes, err := json.Marshal(containerInfo)
+	if err != nil {
+		return err
+	}
+	dirURL := fmt.Sprintf(DefaultInfoLocation, containerInfo.Name)
+	configFilePath := dirURL + ConfigName
+	if _, err := os.Stat(configFilePath); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dirURL, "."+ConfigName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(newContentBytes); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpFile.Name(), 0622); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), configFilePath)
+}
+
 func getContainerPidByName(containerName string) (string, error) {
 	dirURL := fmt.Sprintf(DefaultInfoLocation, containerName)
 	configFilePath := dirURL + ConfigName
@@ -83,3 +135,12 @@ func pathExist(path string) (bool, error) {
 	}
 	return false, err
 }
+
+// containerCgroup returns the cgroup manager of a container, a rootless
+// container may run without cgroup.
+func containerCgroup(containerInfo *ContainerInfo) (*cgroups.CgroupManager, error) {
+	if containerInfo.CgroupPath == "" {
+		return nil, fmt.Errorf("container %s runs without cgroup", containerInfo.Name)
+	}
+	return cgroups.NewCgroupManager(containerInfo.CgroupPath), nil
+}
@@ -1,27 +1,92 @@
 package container
 
 import (
+	"errors"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"strings"
 )
 
-func newWorkSpace(volume, imageName, containerName string) {
+// newWorkSpace creates the layers and mounts the rootfs of a container. In
+// rootless mode it returns the mounts init has to make instead.
+func newWorkSpace(volume, imageName, containerName string, idMappings *IDMappings) []*rootfsMount {
+	createWorkSpaceDirs()
 	createReadOnlyLayer(imageName)
+	imageLocation := RootUrl + "/" + imageName
 	createWriteLayer(containerName)
-	createMountpoint(containerName, imageName)
+	if Rootless {
+		return rootlessWorkSpace(volume, imageLocation, containerName)
+	}
+	if idMappings != nil {
+		imageLocation = createShiftedLayer(imageName, idMappings)
+		shiftWriteLayer(containerName, idMappings)
+	}
+	createMountpoint(containerName, imageLocation)
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
 		length := len(volumeURLs)
 		if length == 2 && volumeURLs[0] != "" && volumeURLs[1] != "" {
 			mountVolume(volumeURLs, containerName)
 			logrus.Infof("newWorkSpace volume urls %q", volumeURLs)
-			return
+			return nil
 		}
 		logrus.Errorf("volume param input incorrect")
 	}
+	return nil
+}
+
+// createWorkSpaceDirs creates the parents of the layers, a rootless runtime
+// starts from an empty data directory.
+func createWorkSpaceDirs() {
+	for _, dir := range []string{LayerUrl + "/temp", path.Dir(MntUrl), path.Dir(WriteLayerUrl)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			logrus.Errorf("mkdir dir %s error; %v", dir, err)
+		}
+	}
+}
+
+// rootlessWorkSpace only prepares the directories, without root the overlay
+// and the volume can only be mounted by init inside its user namespace.
+// Volumes are bind mounts there, aufs does not mount in a user namespace.
+func rootlessWorkSpace(volume, imageLocation, containerName string) []*rootfsMount {
+	mntURL := fmt.Sprintf(MntUrl, containerName)
+	if err := os.Mkdir(mntURL, 0777); err != nil {
+		logrus.Errorf("mkdir dir %s error. %v", mntURL, err)
+		return nil
+	}
+	mounts := []*rootfsMount{{
+		Source: "overlay",
+		Target: mntURL,
+		Fstype: "overlay",
+		Data:   overlayOptions(imageLocation, containerName),
+	}}
+	if volume != "" {
+		volumeURLs := strings.Split(volume, ":")
+		if len(volumeURLs) != 2 || volumeURLs[0] == "" || volumeURLs[1] == "" {
+			logrus.Errorf("volume param input incorrect")
+			return mounts
+		}
+		if err := os.Mkdir(volumeURLs[0], 0777); err != nil {
+			logrus.Infof("Mkdir parent dir %s error. %v", volumeURLs[0], err)
+		}
+		mounts = append(mounts, &rootfsMount{
+			Source: volumeURLs[0],
+			Target: mntURL + "/" + volumeURLs[1],
+			Flags:  unix.MS_BIND | unix.MS_REC,
+		})
+	}
+	return mounts
+}
+
+func overlayOptions(imageLocation, containerName string) string {
+	writeLayer := fmt.Sprintf(WriteLayerUrl, containerName)
+	return fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+		imageLocation, writeLayer, LayerUrl+"/temp")
 }
 
 func createReadOnlyLayer(imageName string) {
@@ -33,7 +98,7 @@ func createReadOnlyLayer(imageName string) {
 		return
 	}
 	if !exist {
-		if err := os.Mkdir(unTarFolderUrl, 0622); err != nil {
+		if err := os.Mkdir(unTarFolderUrl, 0755); err != nil {
 			logrus.Errorf("mkdir dir %s error; %v", unTarFolderUrl, err)
 			return
 		}
@@ -51,20 +116,62 @@ func createWriteLayer(containerName string) {
 	}
 }
 
+// createShiftedLayer copies the read only layer of an image once per user
+// namespace mappings and shifts the owners into them, like docker keeps one
+// image store per remapped root. The copy is shifted in a temporary
+// directory and only renamed into place when complete, a failed run leaves
+// no layer behind that later runs would reuse. It returns the copy.
+func createShiftedLayer(imageName string, idMappings *IDMappings) string {
+	imageLocation := RootUrl + "/" + imageName
+	shiftedLocation := fmt.Sprintf("%s/%s.%s", LayerUrl, imageName, idMappings.Key())
+	exist, err := pathExist(shiftedLocation)
+	if err != nil {
+		logrus.Errorf("fail to judge whether dir %s exists error; %v", shiftedLocation, err)
+		return shiftedLocation
+	}
+	if exist {
+		return shiftedLocation
+	}
+	tmpLocation, err := ioutil.TempDir(LayerUrl, "."+imageName+".")
+	if err != nil {
+		logrus.Errorf("create temp dir for %s error; %v", shiftedLocation, err)
+		return shiftedLocation
+	}
+	defer os.RemoveAll(tmpLocation)
+	// cp -a of the image directory onto an existing directory would nest it.
+	if out, err := exec.Command("cp", "-a", imageLocation+"/.", tmpLocation).CombinedOutput(); err != nil {
+		logrus.Errorf("copy dir %s to %s error; %v: %s", imageLocation, tmpLocation, err, out)
+		return shiftedLocation
+	}
+	if err := shiftOwnership(tmpLocation, idMappings); err != nil {
+		logrus.Errorf("shift owner of %s error; %v", tmpLocation, err)
+		return shiftedLocation
+	}
+	// a concurrent run may have renamed its copy first, either is fine.
+	if err := os.Rename(tmpLocation, shiftedLocation); err != nil && !os.IsExist(err) && !errors.Is(err, unix.ENOTEMPTY) {
+		logrus.Errorf("rename dir %s to %s error; %v", tmpLocation, shiftedLocation, err)
+	}
+	return shiftedLocation
+}
+
+// shiftWriteLayer hands the write layer to root of the container, overlay
+// takes the owner of the root directory from the upper layer.
+func shiftWriteLayer(containerName string, idMappings *IDMappings) {
+	writeURL := fmt.Sprintf(WriteLayerUrl, containerName)
+	if err := os.Chown(writeURL, idMappings.RootUid(), idMappings.RootGid()); err != nil {
+		logrus.Errorf("chown dir %s error; %v", writeURL, err)
+	}
+}
+
 // Union filesystem.
-func createMountpoint(containerName, imageName string) {
+func createMountpoint(containerName, imageLocation string) {
 	mntURL := fmt.Sprintf(MntUrl, containerName)
 	if err := os.Mkdir(mntURL, 0777); err != nil {
 		logrus.Errorf("mkdir dir %s error. %v", mntURL, err)
 		return
 	}
 
-	writeLayer := fmt.Sprintf(WriteLayerUrl, containerName)
-	imageLocation := RootUrl + "/" + imageName
-
-	options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
-		imageLocation, writeLayer, RootUrl+"/temp")
-
+	options := overlayOptions(imageLocation, containerName)
 	cmd := exec.Command("mount", "-t", "overlay", "-o", options, "overlay", mntURL)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -93,7 +200,25 @@ func mountVolume(volumeURLs []string, containerName string) error {
 	return nil
 }
 
+// MountPoint returns the rootfs mount point of a container, remapped
+// containers keep it under their data root.
+func MountPoint(containerName string) string {
+	if containerInfo, err := getContainerInfoByName(containerName); err == nil && containerInfo.IDMappings != nil && !Rootless {
+		return RemapRoot(containerInfo.IDMappings) + "/mnt/" + containerName
+	}
+	return fmt.Sprintf(MntUrl, containerName)
+}
+
 func DeleteWorkSpace(volume, containerName string) {
+	// the mounts of a rootless container die with its mount namespace.
+	if Rootless {
+		mntURL := fmt.Sprintf(MntUrl, containerName)
+		if err := os.RemoveAll(mntURL); err != nil {
+			logrus.Errorf("remove dir %s error; %v", mntURL, err)
+		}
+		deleteWriteLayer(containerName)
+		return
+	}
 	if volume != "" {
 		volumeURLs := strings.Split(volume, ":")
 		length := len(volumeURLs)
@@ -176,3 +176,28 @@ func setupIpTables(bridgeName string, subnet string) error {
 
 	return nil
 }
+
+// tcCgroupHandle is the handle of the htb qdisc, packets of a container
+// started with --net-classid 10:<minor> are sorted into class 10:<minor>.
+const tcCgroupHandle = "10:"
+
+// setupTcCgroupFilter installs a htb qdisc with a cgroup filter on the
+// container end of the veth, the host then shapes a container class with
+// `tc class add dev <if> parent 10: classid 10:<minor> htb rate ...` in the
+// container network namespace. The filter can not sit on the bridge: the
+// classid is read from the sending socket, which the packet leaves behind
+// when it crosses the veth pair. Packets without a matching class pass unshaped.
+func setupTcCgroupFilter(ifName string) error {
+	// bash: tc qdisc add dev <if> root handle 10: htb
+	qdiscCmd := fmt.Sprintf("qdisc add dev %s root handle %s htb", ifName, tcCgroupHandle)
+	if output, err := exec.Command("tc", strings.Split(qdiscCmd, " ")...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tc %s: %v, %s", qdiscCmd, err, output)
+	}
+
+	// bash: tc filter add dev <if> parent 10: protocol all prio 10 handle 1: cgroup
+	filterCmd := fmt.Sprintf("filter add dev %s parent %s protocol all prio 10 handle 1: cgroup", ifName, tcCgroupHandle)
+	if output, err := exec.Command("tc", strings.Split(filterCmd, " ")...).CombinedOutput(); err != nil {
+		return fmt.Errorf("tc %s: %v, %s", filterCmd, err, output)
+	}
+	return nil
+}
@@ -31,6 +31,9 @@ type (
 		// The name of network drive.
 		Subnet  string
 		Gateway string
+		// TcCgroupFilter installs a htb qdisc with a tc cgroup filter on
+		// every endpoint, see setupTcCgroupFilter.
+		TcCgroupFilter bool
 	}
 
 	Endpoint struct {
@@ -64,20 +67,20 @@ func (nw *Network) dump(dumpPath string) error {
 	nwPath := path.Join(dumpPath, nw.Name)
 	nwFile, err := os.OpenFile(nwPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 	defer nwFile.Close()
 
 	nwJson, err := json.Marshal(nw)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 
 	_, err = nwFile.Write(nwJson)
 	if err != nil {
-		logrus.Errorf("error：", err)
+		logrus.Errorf("error：%v", err)
 		return err
 	}
 	return nil
@@ -109,7 +112,7 @@ func (nw *Network) load(dumpPath string) error {
 
 	err = json.Unmarshal(nwJson[:n], nw)
 	if err != nil {
-		logrus.Errorf("Error load nw info", err)
+		logrus.Errorf("Error load nw info; %v", err)
 		return err
 	}
 	return nil
@@ -145,11 +148,14 @@ func Init() error {
 	return nil
 }
 
-func CreateNetwork(driver, subnet, name string) error {
+func CreateNetwork(driver, subnet, name string, tcCgroupFilter bool) error {
 	_, exist := networks[name]
 	if exist {
 		return fmt.Errorf("network with name %s already exists", name)
 	}
+	if tcCgroupFilter && driver != "bridge" {
+		return fmt.Errorf("tc cgroup filter is only supported by the bridge driver")
+	}
 
 	// 将网段字符串转换为net.IPNet
 	_, cider, _ := net.ParseCIDR(subnet)
@@ -164,6 +170,7 @@ func CreateNetwork(driver, subnet, name string) error {
 	if err != nil {
 		return err
 	}
+	nw.TcCgroupFilter = tcCgroupFilter
 
 	return nw.dump(defaultNetworkPath)
 }
@@ -270,6 +277,12 @@ func configEndpointIpAddrAndRoute(ep *Endpoint, pid string) error {
 		return err
 	}
 
+	if ep.Network.TcCgroupFilter {
+		if err = setupTcCgroupFilter(vethPeerName); err != nil {
+			return fmt.Errorf("set network %s tc cgroup filter error: %v", ep.Network.Name, err)
+		}
+	}
+
 	return nil
 
 }
@@ -8,37 +8,201 @@ package nsenter
 #include <stdlib.h>
 #include <string.h>
 #include <fcntl.h>
+#include <grp.h>
+#include <sys/stat.h>
 #include <unistd.h>
+#include <sys/prctl.h>
+#include <sys/syscall.h>
+#include <linux/capability.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
+
+static int last_cap(void){
+    int last=40;
+    FILE *f=fopen("/proc/sys/kernel/cap_last_cap","r");
+    if(f){
+        if(fscanf(f,"%d",&last)!=1){
+            last=40;
+        }
+        fclose(f);
+    }
+    return last;
+}
+
+// apply_caps limits every capability set to mask, like init does before it
+// executes the user process.
+static int apply_caps(unsigned long long mask){
+    int i;
+    int last=last_cap();
+    if(last<63){
+        mask&=(1ULL<<(last+1))-1;
+    }
+    for(i=0;i<=last;i++){
+        if(!(mask&(1ULL<<i))&&prctl(PR_CAPBSET_DROP,i,0,0,0)==-1){
+            return -1;
+        }
+    }
+    struct __user_cap_header_struct header={_LINUX_CAPABILITY_VERSION_3,0};
+    struct __user_cap_data_struct data[2];
+    for(i=0;i<2;i++){
+        __u32 set=(__u32)(mask>>(32*i));
+        data[i].effective=set;
+        data[i].permitted=set;
+        data[i].inheritable=set;
+    }
+    if(syscall(SYS_capset,&header,data)==-1){
+        return -1;
+    }
+    // kernels before 4.3 have no ambient set.
+    if(prctl(PR_CAP_AMBIENT,PR_CAP_AMBIENT_CLEAR_ALL,0,0,0)==-1){
+        return 0;
+    }
+    for(i=0;i<=last;i++){
+        if((mask&(1ULL<<i))&&prctl(PR_CAP_AMBIENT,PR_CAP_AMBIENT_RAISE,i,0,0)==-1){
+            return -1;
+        }
+    }
+    return 0;
+}
+
+static unsigned long hex_field(const char *s,int len){
+    char buf[9]={0};
+    memcpy(buf,s,len);
+    return strtoul(buf,NULL,16);
+}
+
+// install_seccomp installs the filter of the container, given as the flags
+// and each instruction as code, jt, jf and k in hexadecimal.
+static int install_seccomp(const char *spec){
+    char *insns;
+    unsigned long flags=strtoul(spec,&insns,16);
+    if(*insns!=':'){
+        errno=EINVAL;
+        return -1;
+    }
+    insns++;
+    size_t len=strlen(insns);
+    if(len==0||len%16!=0){
+        errno=EINVAL;
+        return -1;
+    }
+    unsigned short count=len/16;
+    struct sock_filter *filter=calloc(count,sizeof(struct sock_filter));
+    if(!filter){
+        return -1;
+    }
+    int i;
+    for(i=0;i<count;i++){
+        const char *insn=insns+16*i;
+        filter[i].code=hex_field(insn,4);
+        filter[i].jt=hex_field(insn+4,2);
+        filter[i].jf=hex_field(insn+6,2);
+        filter[i].k=hex_field(insn+8,8);
+    }
+    struct sock_fprog prog={count,filter};
+    int res=syscall(SYS_seccomp,SECCOMP_SET_MODE_FILTER,flags,&prog);
+    free(filter);
+    return res;
+}
+
 __attribute__((constructor)) void enter_namespace(void){
-    char* my_docker_pid;
-    my_docker_pid=getenv("my_docker_pid");
-    if(my_docker_pid){
-        //fprintf(stdout, "got my_docker_pid=%s\n",my_docker_pid);
+    char* myrunc_pid;
+    myrunc_pid=getenv("myrunc_pid");
+    if(myrunc_pid){
+        //fprintf(stdout, "got myrunc_pid=%s\n",myrunc_pid);
     }else{
-        //fprintf(stdout, "missing my_docker_pid env, skip nsenter\n",my_docker_pid);
+        //fprintf(stdout, "missing myrunc_pid env, skip nsenter\n",myrunc_pid);
         return;
     }
-    char *my_docker_cmd;
-    my_docker_cmd=getenv("my_docker_cmd");
-    if(my_docker_cmd){
-        //fprintf(stdout, "got my_docker_cmd=%s\n",my_docker_pid);
+    char *myrunc_cmd;
+    myrunc_cmd=getenv("myrunc_cmd");
+    if(myrunc_cmd){
+        //fprintf(stdout, "got myrunc_cmd=%s\n",myrunc_pid);
     }else{
-        //fprintf(stdout, "missing my_docker_cmd env, skip nsenter\n",my_docker_pid);
+        //fprintf(stdout, "missing myrunc_cmd env, skip nsenter\n",myrunc_pid);
         return;
     }
     int i;
     char nspath[1024];
-    char *namespaces[]={"ipc","uts","net","pid","mnt"};
-    for(i=0;i<5;i++){
+    // the user namespace goes first, the other namespaces of the container
+    // belong to it and joining them needs its capabilities.
+    if(getenv("myrunc_userns")){
+        sprintf(nspath,"/proc/%s/ns/user",myrunc_pid);
+        int fd=open(nspath,O_RDONLY);
+        if(setns(fd,CLONE_NEWUSER)==-1){
+            fprintf(stderr, "setns on user namespace failed: %s\n", strerror(errno));
+            exit(1);
+        }
+        close(fd);
+        // a rootless container denies setgroups, its groups can not be dropped.
+        int setgroups_allowed=1;
+        char setgroups_state[16]={0};
+        sprintf(nspath,"/proc/%s/setgroups",myrunc_pid);
+        int sfd=open(nspath,O_RDONLY);
+        if(sfd!=-1){
+            if(read(sfd,setgroups_state,sizeof(setgroups_state)-1)>0&&strncmp(setgroups_state,"deny",4)==0){
+                setgroups_allowed=0;
+            }
+            close(sfd);
+        }
+        // become root of the container, host root is not mapped in it.
+        if((setgroups_allowed&&setgroups(0,NULL)==-1)||setresgid(0,0,0)==-1||setresuid(0,0,0)==-1){
+            fprintf(stderr, "set root of user namespace failed: %s\n", strerror(errno));
+            exit(1);
+        }
+    }
+    char *namespaces[]={"ipc","uts","net","pid","cgroup","mnt"};
+    for(i=0;i<6;i++){
         // e.g. /proc/pid/ns/ipc
-        sprintf(nspath,"/proc/%s/ns/%s",my_docker_pid,namespaces[i]);
+        sprintf(nspath,"/proc/%s/ns/%s",myrunc_pid,namespaces[i]);
+        // skip the namespaces shared with the host, a process in a user
+        // namespace may not join namespaces of the initial one.
+        char selfpath[1024];
+        struct stat target_st, self_st;
+        sprintf(selfpath,"/proc/self/ns/%s",namespaces[i]);
+        if(stat(nspath,&target_st)==0&&stat(selfpath,&self_st)==0&&target_st.st_ino==self_st.st_ino&&target_st.st_dev==self_st.st_dev){
+            continue;
+        }
         int fd=open(nspath,O_RDONLY);
         if(setns(fd,0)==-1){
             fprintf(stderr, "setns on %s namespace failed: %s\n",namespaces[i], strerror(errno));
         }
         close(fd);
     }
-    int res=system(my_docker_cmd);
+    // like init, without no_new_privs the filter needs CAP_SYS_ADMIN and
+    // goes in before the capabilities are dropped.
+    int no_new_privs=getenv("myrunc_no_new_privs")!=NULL;
+    if(no_new_privs){
+        if(prctl(PR_SET_NO_NEW_PRIVS,1,0,0,0)==-1){
+            fprintf(stderr, "set no_new_privs failed: %s\n", strerror(errno));
+            exit(1);
+        }
+        unsetenv("myrunc_no_new_privs");
+    }
+    char *myrunc_seccomp=getenv("myrunc_seccomp_filter");
+    if(myrunc_seccomp&&!no_new_privs){
+        if(install_seccomp(myrunc_seccomp)==-1){
+            fprintf(stderr, "install seccomp filter failed: %s\n", strerror(errno));
+            exit(1);
+        }
+    }
+    // exec'd processes get the capabilities of the container.
+    char *myrunc_caps=getenv("myrunc_caps");
+    if(myrunc_caps){
+        if(apply_caps(strtoull(myrunc_caps,NULL,16))==-1){
+            fprintf(stderr, "apply capabilities failed: %s\n", strerror(errno));
+            exit(1);
+        }
+        unsetenv("myrunc_caps");
+    }
+    if(myrunc_seccomp&&no_new_privs){
+        if(install_seccomp(myrunc_seccomp)==-1){
+            fprintf(stderr, "install seccomp filter failed: %s\n", strerror(errno));
+            exit(1);
+        }
+    }
+    unsetenv("myrunc_seccomp_filter");
+    int res=system(myrunc_cmd);
     exit(0);
     return;
 }
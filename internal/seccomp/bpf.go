@@ -0,0 +1,453 @@
+package seccomp
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+	"runtime"
+)
+
+// return values of a filter, see seccomp(2).
+const (
+	retKillProcess uint32 = 0x80000000
+	retKillThread  uint32 = 0x00000000
+	retTrap        uint32 = 0x00030000
+	retErrno       uint32 = 0x00050000
+	retTrace       uint32 = 0x7ff00000
+	retLog         uint32 = 0x7ffc0000
+	retAllow       uint32 = 0x7fff0000
+)
+
+// audit architectures of struct seccomp_data, see include/uapi/linux/audit.h.
+const (
+	auditArchI386    uint32 = 0x40000003
+	auditArchX86_64  uint32 = 0xc000003e
+	auditArchArm     uint32 = 0x40000028
+	auditArchAarch64 uint32 = 0xc00000b7
+)
+
+// offsets in struct seccomp_data, the arguments are 64 bit and every
+// supported architecture is little endian.
+const (
+	offsetNr   = 0
+	offsetArch = 4
+	offsetArgs = 16
+)
+
+// x32SyscallBit marks the system calls of the x32 ABI, which share the
+// audit architecture of x86_64.
+const x32SyscallBit uint32 = 0x40000000
+
+// maxInstructions is BPF_MAXINSNS, the kernel rejects longer filters.
+const maxInstructions = 4096
+
+type archInfo struct {
+	audit    uint32
+	syscalls map[string]uint32
+	// nrOffset is added to the system call numbers.
+	nrOffset uint32
+	// arg32 architectures pass 32 bit arguments, only the lower half is
+	// compared like libseccomp does.
+	arg32 bool
+}
+
+var archInfos = map[Arch]*archInfo{
+	ArchX86_64:  {audit: auditArchX86_64, syscalls: syscallsX86_64},
+	ArchX32:     {audit: auditArchX86_64, syscalls: syscallsX32, nrOffset: x32SyscallBit, arg32: true},
+	ArchX86:     {audit: auditArchI386, syscalls: syscallsX86, arg32: true},
+	ArchAARCH64: {audit: auditArchAarch64, syscalls: syscallsAarch64},
+	ArchARM:     {audit: auditArchArm, syscalls: syscallsArm, arg32: true},
+}
+
+// nativeArch is the architecture of the runtime, the one of the container.
+func nativeArch() (Arch, error) {
+	switch runtime.GOARCH {
+	case "amd64":
+		return ArchX86_64, nil
+	case "386":
+		return ArchX86, nil
+	case "arm64":
+		return ArchAARCH64, nil
+	case "arm":
+		return ArchARM, nil
+	}
+	return "", fmt.Errorf("seccomp is not supported on %s", runtime.GOARCH)
+}
+
+// Program is a compiled seccomp filter.
+type Program struct {
+	Instructions []unix.SockFilter
+	// Flags are the SECCOMP_FILTER_FLAG_* of the profile.
+	Flags uintptr
+}
+
+type rule struct {
+	ret  uint32
+	args []*Arg
+}
+
+// syscallRules are the rules of one system call in profile order.
+type syscallRules struct {
+	nr    uint32
+	rules []*rule
+}
+
+// Compile translates a profile into a classic BPF program for the native
+// architecture and the architectures it maps to. caps are the capabilities
+// of the container, they select the rules with includes and excludes.
+//
+// The program checks the architecture first, a system call of an
+// architecture the profile does not cover kills the process. Within an
+// architecture the rules of a system call are tried in order, the action
+// of the first one whose arguments all match is returned, otherwise the
+// default action.
+func Compile(profile *Profile, caps []string) (*Program, error) {
+	defaultRet, err := actionRet(profile.DefaultAction, profile.DefaultErrnoRet)
+	if err != nil {
+		return nil, fmt.Errorf("default action %v", err)
+	}
+	flags, err := filterFlags(profile.Flags)
+	if err != nil {
+		return nil, err
+	}
+	arches, err := profileArches(profile)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range profile.Syscalls {
+		if len(s.names()) == 0 {
+			return nil, fmt.Errorf("syscall rule without names")
+		}
+		for _, arg := range s.Args {
+			if arg.Index > 5 {
+				return nil, fmt.Errorf("syscall %s argument index %d out of range", s.names()[0], arg.Index)
+			}
+		}
+	}
+
+	// x86_64 and x32 share an audit architecture and are told apart by
+	// x32SyscallBit, every other architecture has a section of its own.
+	var audits []uint32
+	sections := map[uint32]map[bool]*archInfo{}
+	for _, arch := range arches {
+		info := archInfos[arch]
+		if sections[info.audit] == nil {
+			audits = append(audits, info.audit)
+			sections[info.audit] = map[bool]*archInfo{}
+		}
+		sections[info.audit][info.nrOffset != 0] = info
+	}
+
+	a := &assembler{}
+	a.load(offsetArch)
+	for _, audit := range audits {
+		next := a.newLabel()
+		a.jumpUnless(unix.BPF_JEQ, audit, next)
+		a.load(offsetNr)
+		if audit == auditArchX86_64 {
+			x32 := a.newLabel()
+			a.jumpIf(unix.BPF_JGE, x32SyscallBit, x32)
+			if err := a.archRules(sections[audit][false], profile, caps, defaultRet); err != nil {
+				return nil, err
+			}
+			a.bind(x32)
+			if err := a.archRules(sections[audit][true], profile, caps, defaultRet); err != nil {
+				return nil, err
+			}
+		} else if err := a.archRules(sections[audit][false], profile, caps, defaultRet); err != nil {
+			return nil, err
+		}
+		a.bind(next)
+	}
+	a.ret(retKillProcess)
+
+	instructions, err := a.assemble()
+	if err != nil {
+		return nil, err
+	}
+	if len(instructions) > maxInstructions {
+		return nil, fmt.Errorf("seccomp filter has %d instructions, more than %d", len(instructions), maxInstructions)
+	}
+	return &Program{Instructions: instructions, Flags: flags}, nil
+}
+
+// profileArches are the native architecture and the ones the profile adds
+// for it, through archMap in docker profiles or architectures in OCI ones.
+func profileArches(profile *Profile) ([]Arch, error) {
+	native, err := nativeArch()
+	if err != nil {
+		return nil, err
+	}
+	candidates := profile.Architectures
+	if len(profile.ArchMap) > 0 {
+		candidates = nil
+		for _, m := range profile.ArchMap {
+			if m.Arch == native {
+				candidates = append(candidates, m.SubArchitectures...)
+			}
+		}
+	}
+	arches := []Arch{native}
+	for _, arch := range candidates {
+		if _, ok := archInfos[arch]; !ok {
+			logrus.Warnf("seccomp architecture %s is not supported, skip it", arch)
+			continue
+		}
+		duplicate := false
+		for _, a := range arches {
+			duplicate = duplicate || a == arch
+		}
+		if !duplicate {
+			arches = append(arches, arch)
+		}
+	}
+	return arches, nil
+}
+
+// archRules emits the rules of an architecture, without info the
+// architecture is not covered and its system calls kill the process.
+func (a *assembler) archRules(info *archInfo, profile *Profile, caps []string, defaultRet uint32) error {
+	if info == nil {
+		a.ret(retKillProcess)
+		return nil
+	}
+	var ordered []*syscallRules
+	byNr := map[uint32]*syscallRules{}
+	for _, s := range profile.Syscalls {
+		if !s.applies(caps) {
+			continue
+		}
+		ret, err := actionRet(s.Action, s.ErrnoRet)
+		if err != nil {
+			return fmt.Errorf("syscall %s action %v", s.names()[0], err)
+		}
+		for _, name := range s.names() {
+			// like libseccomp, names the architecture lacks are skipped.
+			nr, ok := info.syscalls[name]
+			if !ok {
+				continue
+			}
+			nr += info.nrOffset
+			if byNr[nr] == nil {
+				byNr[nr] = &syscallRules{nr: nr}
+				ordered = append(ordered, byNr[nr])
+			}
+			byNr[nr].rules = append(byNr[nr].rules, &rule{ret: ret, args: s.Args})
+		}
+	}
+
+	for _, sr := range ordered {
+		if len(sr.rules) == 1 && len(sr.rules[0].args) == 0 {
+			a.jump(unix.BPF_JEQ, sr.nr, 0, 1)
+			a.ret(sr.rules[0].ret)
+			continue
+		}
+		next := a.newLabel()
+		a.jumpUnless(unix.BPF_JEQ, sr.nr, next)
+		for _, r := range sr.rules {
+			fail := a.newLabel()
+			for _, arg := range r.args {
+				if err := a.compare(arg, info.arg32, fail); err != nil {
+					return err
+				}
+			}
+			a.ret(r.ret)
+			a.bind(fail)
+		}
+		a.ret(defaultRet)
+		a.bind(next)
+	}
+	a.ret(defaultRet)
+	return nil
+}
+
+// compare continues when the argument matches and jumps to fail otherwise.
+// 64 bit arguments are compared as two 32 bit halves, the upper one first.
+func (a *assembler) compare(arg *Arg, arg32 bool, fail *label) error {
+	lo := offsetArgs + 8*uint32(arg.Index)
+	hi := lo + 4
+	value, valueTwo := arg.Value, arg.ValueTwo
+	vlo, vhi := uint32(value), uint32(value>>32)
+
+	if arg32 {
+		a.load(lo)
+		switch arg.Op {
+		case OpEqualTo:
+			a.jumpUnless(unix.BPF_JEQ, vlo, fail)
+		case OpNotEqual:
+			a.jumpIf(unix.BPF_JEQ, vlo, fail)
+		case OpGreaterThan:
+			a.jumpUnless(unix.BPF_JGT, vlo, fail)
+		case OpGreaterEqual:
+			a.jumpUnless(unix.BPF_JGE, vlo, fail)
+		case OpLessThan:
+			a.jumpIf(unix.BPF_JGE, vlo, fail)
+		case OpLessEqual:
+			a.jumpIf(unix.BPF_JGT, vlo, fail)
+		case OpMaskedEqual:
+			a.stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, vlo)
+			a.jumpUnless(unix.BPF_JEQ, uint32(valueTwo), fail)
+		default:
+			return fmt.Errorf("unknown seccomp operator %s", arg.Op)
+		}
+		return nil
+	}
+
+	pass := a.newLabel()
+	switch arg.Op {
+	case OpEqualTo:
+		a.load(hi)
+		a.jumpUnless(unix.BPF_JEQ, vhi, fail)
+		a.load(lo)
+		a.jumpUnless(unix.BPF_JEQ, vlo, fail)
+	case OpNotEqual:
+		a.load(hi)
+		a.jumpUnless(unix.BPF_JEQ, vhi, pass)
+		a.load(lo)
+		a.jumpIf(unix.BPF_JEQ, vlo, fail)
+	case OpGreaterThan, OpGreaterEqual:
+		a.load(hi)
+		a.jumpIf(unix.BPF_JGT, vhi, pass)
+		a.jumpUnless(unix.BPF_JEQ, vhi, fail)
+		a.load(lo)
+		if arg.Op == OpGreaterThan {
+			a.jumpUnless(unix.BPF_JGT, vlo, fail)
+		} else {
+			a.jumpUnless(unix.BPF_JGE, vlo, fail)
+		}
+	case OpLessThan, OpLessEqual:
+		a.load(hi)
+		a.jumpUnless(unix.BPF_JGE, vhi, pass)
+		a.jumpUnless(unix.BPF_JEQ, vhi, fail)
+		a.load(lo)
+		if arg.Op == OpLessThan {
+			a.jumpIf(unix.BPF_JGE, vlo, fail)
+		} else {
+			a.jumpIf(unix.BPF_JGT, vlo, fail)
+		}
+	case OpMaskedEqual:
+		a.load(hi)
+		a.stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, vhi)
+		a.jumpUnless(unix.BPF_JEQ, uint32(valueTwo>>32), fail)
+		a.load(lo)
+		a.stmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, vlo)
+		a.jumpUnless(unix.BPF_JEQ, uint32(valueTwo), fail)
+	default:
+		return fmt.Errorf("unknown seccomp operator %s", arg.Op)
+	}
+	a.bind(pass)
+	return nil
+}
+
+// actionRet is the filter return value of an action, errno defaults to
+// EPERM for SCMP_ACT_ERRNO and SCMP_ACT_TRACE.
+func actionRet(action Action, errnoRet *uint) (uint32, error) {
+	data := uint32(unix.EPERM)
+	if errnoRet != nil {
+		data = uint32(*errnoRet) & 0xffff
+	}
+	switch action {
+	case ActKill, ActKillThread:
+		return retKillThread, nil
+	case ActKillProcess:
+		return retKillProcess, nil
+	case ActTrap:
+		return retTrap, nil
+	case ActErrno:
+		return retErrno | data, nil
+	case ActTrace:
+		return retTrace | data, nil
+	case ActLog:
+		return retLog, nil
+	case ActAllow:
+		return retAllow, nil
+	}
+	return 0, fmt.Errorf("%q is not supported", action)
+}
+
+func filterFlags(names []string) (uintptr, error) {
+	var flags uintptr
+	for _, name := range names {
+		switch name {
+		case "SECCOMP_FILTER_FLAG_TSYNC":
+			// always set, see Install.
+		case "SECCOMP_FILTER_FLAG_LOG":
+			flags |= seccompFilterFlagLog
+		case "SECCOMP_FILTER_FLAG_SPEC_ALLOW":
+			flags |= seccompFilterFlagSpecAllow
+		default:
+			return 0, fmt.Errorf("unknown seccomp flag %s", name)
+		}
+	}
+	return flags, nil
+}
+
+// label is a position in the program, jumps to it are resolved once the
+// program is complete.
+type label struct {
+	pos int
+}
+
+type fixup struct {
+	insn  int
+	label *label
+}
+
+// assembler builds a program. Conditional jumps only reach 255
+// instructions ahead, so they skip at most one instruction and long jumps
+// go through BPF_JA, whose offset is 32 bit.
+type assembler struct {
+	insns  []unix.SockFilter
+	fixups []fixup
+}
+
+func (a *assembler) newLabel() *label {
+	return &label{pos: -1}
+}
+
+func (a *assembler) bind(l *label) {
+	l.pos = len(a.insns)
+}
+
+func (a *assembler) stmt(code uint16, k uint32) {
+	a.insns = append(a.insns, unix.SockFilter{Code: code, K: k})
+}
+
+func (a *assembler) load(offset uint32) {
+	a.stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offset)
+}
+
+func (a *assembler) ret(k uint32) {
+	a.stmt(unix.BPF_RET|unix.BPF_K, k)
+}
+
+func (a *assembler) jump(op uint16, k uint32, jt, jf uint8) {
+	a.insns = append(a.insns, unix.SockFilter{Code: unix.BPF_JMP | op | unix.BPF_K, Jt: jt, Jf: jf, K: k})
+}
+
+func (a *assembler) jumpAlways(l *label) {
+	a.fixups = append(a.fixups, fixup{insn: len(a.insns), label: l})
+	a.stmt(unix.BPF_JMP|unix.BPF_JA, 0)
+}
+
+// jumpIf jumps to l when the accumulator compared to k by op holds.
+func (a *assembler) jumpIf(op uint16, k uint32, l *label) {
+	a.jump(op, k, 0, 1)
+	a.jumpAlways(l)
+}
+
+// jumpUnless jumps to l when the comparison does not hold.
+func (a *assembler) jumpUnless(op uint16, k uint32, l *label) {
+	a.jump(op, k, 1, 0)
+	a.jumpAlways(l)
+}
+
+func (a *assembler) assemble() ([]unix.SockFilter, error) {
+	for _, f := range a.fixups {
+		if f.label.pos < 0 {
+			return nil, fmt.Errorf("seccomp filter jumps to an unbound label")
+		}
+		a.insns[f.insn].K = uint32(f.label.pos - f.insn - 1)
+	}
+	return a.insns, nil
+}
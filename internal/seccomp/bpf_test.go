@@ -0,0 +1,378 @@
+package seccomp
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+// seccompData is struct seccomp_data, the input of a filter.
+type seccompData struct {
+	nr   uint32
+	arch uint32
+	args [6]uint64
+}
+
+func (d *seccompData) word(offset uint32) (uint32, error) {
+	switch {
+	case offset == offsetNr:
+		return d.nr, nil
+	case offset == offsetArch:
+		return d.arch, nil
+	case offset >= offsetArgs && offset < offsetArgs+48 && offset%4 == 0:
+		arg := d.args[(offset-offsetArgs)/8]
+		if (offset-offsetArgs)%8 == 4 {
+			return uint32(arg >> 32), nil
+		}
+		return uint32(arg), nil
+	}
+	return 0, fmt.Errorf("load of offset %d", offset)
+}
+
+// runFilter interprets the instructions Compile emits the way the kernel
+// does, jumps out of the program are an error.
+func runFilter(insns []unix.SockFilter, data *seccompData) (uint32, error) {
+	var acc uint32
+	for pc := 0; pc < len(insns); pc++ {
+		insn := insns[pc]
+		switch insn.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			word, err := data.word(insn.K)
+			if err != nil {
+				return 0, err
+			}
+			acc = word
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			acc &= insn.K
+		case unix.BPF_RET | unix.BPF_K:
+			return insn.K, nil
+		case unix.BPF_JMP | unix.BPF_JA:
+			pc += int(insn.K)
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGT | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			var holds bool
+			switch insn.Code & 0xf0 {
+			case unix.BPF_JEQ:
+				holds = acc == insn.K
+			case unix.BPF_JGT:
+				holds = acc > insn.K
+			case unix.BPF_JGE:
+				holds = acc >= insn.K
+			}
+			if holds {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		default:
+			return 0, fmt.Errorf("unknown instruction %#x at %d", insn.Code, pc)
+		}
+		if pc+1 >= len(insns) {
+			return 0, fmt.Errorf("instruction %d jumps out of the program", pc)
+		}
+	}
+	return 0, fmt.Errorf("program does not return")
+}
+
+func skipUnlessAmd64(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skipf("system call numbers are those of x86_64, not %s", runtime.GOARCH)
+	}
+}
+
+func uintPtr(v uint) *uint {
+	return &v
+}
+
+func TestCompileArchChecks(t *testing.T) {
+	skipUnlessAmd64(t)
+	syscalls := []*Syscall{{Names: []string{"getpid"}, Action: ActAllow}}
+	eperm := retErrno | uint32(unix.EPERM)
+	tests := []struct {
+		name    string
+		profile *Profile
+		arch    uint32
+		nr      uint32
+		want    uint32
+	}{
+		{"native", &Profile{}, auditArchX86_64, 39, retAllow},
+		{"native default", &Profile{}, auditArchX86_64, 0, eperm},
+		{"native without x32", &Profile{}, auditArchX86_64, x32SyscallBit | 39, retKillProcess},
+		{"native without x86", &Profile{}, auditArchI386, 20, retKillProcess},
+		{"foreign arch", &Profile{}, auditArchAarch64, 172, retKillProcess},
+		{"architectures x86", &Profile{Architectures: []Arch{ArchX86_64, ArchX86, ArchX32}}, auditArchI386, 20, retAllow},
+		{"architectures x86 numbers", &Profile{Architectures: []Arch{ArchX86_64, ArchX86, ArchX32}}, auditArchI386, 39, eperm},
+		{"architectures x32", &Profile{Architectures: []Arch{ArchX86_64, ArchX86, ArchX32}}, auditArchX86_64, x32SyscallBit | 39, retAllow},
+		{"architectures x32 default", &Profile{Architectures: []Arch{ArchX86, ArchX32}}, auditArchX86_64, x32SyscallBit | 0, eperm},
+		{"architectures native", &Profile{Architectures: []Arch{ArchX86, ArchX32}}, auditArchX86_64, 39, retAllow},
+		{"arch map", &Profile{ArchMap: []ArchMap{{Arch: ArchX86_64, SubArchitectures: []Arch{ArchX86}}}}, auditArchI386, 20, retAllow},
+		{"arch map without x32", &Profile{ArchMap: []ArchMap{{Arch: ArchX86_64, SubArchitectures: []Arch{ArchX86}}}}, auditArchX86_64, x32SyscallBit | 39, retKillProcess},
+		{"arch map of other arch", &Profile{ArchMap: []ArchMap{{Arch: ArchAARCH64, SubArchitectures: []Arch{ArchARM}}}}, auditArchArm, 20, retKillProcess},
+		{"unsupported arch", &Profile{Architectures: []Arch{"SCMP_ARCH_MIPS"}}, auditArchX86_64, 39, retAllow},
+	}
+	for _, tt := range tests {
+		tt.profile.DefaultAction = ActErrno
+		tt.profile.Syscalls = syscalls
+		program, err := Compile(tt.profile, nil)
+		if err != nil {
+			t.Errorf("%s: Compile error %v", tt.name, err)
+			continue
+		}
+		got, err := runFilter(program.Instructions, &seccompData{arch: tt.arch, nr: tt.nr})
+		if err != nil {
+			t.Errorf("%s: run filter error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: filter returns %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompileArgs(t *testing.T) {
+	skipUnlessAmd64(t)
+	const hi = 1 << 32
+	tests := []struct {
+		arg   Arg
+		value uint64
+		want  bool
+	}{
+		{Arg{Op: OpEqualTo, Value: 8}, 8, true},
+		{Arg{Op: OpEqualTo, Value: 8}, hi + 8, false},
+		{Arg{Op: OpEqualTo, Value: hi + 8}, hi + 8, true},
+		{Arg{Op: OpNotEqual, Value: 8}, 8, false},
+		{Arg{Op: OpNotEqual, Value: 8}, hi + 8, true},
+		{Arg{Op: OpNotEqual, Value: 8}, 9, true},
+		{Arg{Op: OpGreaterThan, Value: 8}, 9, true},
+		{Arg{Op: OpGreaterThan, Value: 8}, 8, false},
+		{Arg{Op: OpGreaterThan, Value: hi}, 9, false},
+		{Arg{Op: OpGreaterThan, Value: 9}, hi, true},
+		{Arg{Op: OpGreaterEqual, Value: 8}, 8, true},
+		{Arg{Op: OpGreaterEqual, Value: 8}, 7, false},
+		{Arg{Op: OpGreaterEqual, Value: hi + 1}, hi, false},
+		{Arg{Op: OpLessThan, Value: 8}, 7, true},
+		{Arg{Op: OpLessThan, Value: 8}, 8, false},
+		{Arg{Op: OpLessThan, Value: hi}, 0xffffffff, true},
+		{Arg{Op: OpLessThan, Value: 8}, hi, false},
+		{Arg{Op: OpLessEqual, Value: 8}, 8, true},
+		{Arg{Op: OpLessEqual, Value: 8}, 9, false},
+		{Arg{Op: OpLessEqual, Value: hi + 8}, hi + 8, true},
+		{Arg{Op: OpMaskedEqual, Value: 0xff, ValueTwo: 0x08}, 0x1208, true},
+		{Arg{Op: OpMaskedEqual, Value: 0xff, ValueTwo: 0x08}, 0x1209, false},
+		{Arg{Op: OpMaskedEqual, Value: hi | 0xff, ValueTwo: 0x08}, hi | 0x08, false},
+	}
+	for _, tt := range tests {
+		for _, index := range []uint{0, 5} {
+			arg := tt.arg
+			arg.Index = index
+			profile := &Profile{
+				DefaultAction: ActErrno,
+				Syscalls:      []*Syscall{{Names: []string{"personality"}, Action: ActAllow, Args: []*Arg{&arg}}},
+			}
+			program, err := Compile(profile, nil)
+			if err != nil {
+				t.Errorf("%s %#x: Compile error %v", tt.arg.Op, tt.arg.Value, err)
+				continue
+			}
+			data := &seccompData{arch: auditArchX86_64, nr: 135}
+			data.args[index] = tt.value
+			got, err := runFilter(program.Instructions, data)
+			if err != nil {
+				t.Errorf("%s %#x: run filter error %v", tt.arg.Op, tt.arg.Value, err)
+				continue
+			}
+			if (got == retAllow) != tt.want {
+				t.Errorf("%s %#x of arg %d %#x returns %#x, want match %v", tt.arg.Op, tt.arg.Value, index, tt.value, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCompileArgs32(t *testing.T) {
+	skipUnlessAmd64(t)
+	tests := []struct {
+		arg   Arg
+		value uint64
+		want  bool
+	}{
+		// only the lower half is compared on 32 bit architectures.
+		{Arg{Op: OpEqualTo, Value: 8}, 8, true},
+		{Arg{Op: OpEqualTo, Value: 8}, 1<<32 + 8, true},
+		{Arg{Op: OpNotEqual, Value: 8}, 8, false},
+		{Arg{Op: OpGreaterThan, Value: 8}, 9, true},
+		{Arg{Op: OpGreaterEqual, Value: 8}, 7, false},
+		{Arg{Op: OpLessThan, Value: 8}, 7, true},
+		{Arg{Op: OpLessEqual, Value: 8}, 9, false},
+		{Arg{Op: OpMaskedEqual, Value: 0xf0, ValueTwo: 0x10}, 0x1f, true},
+	}
+	for _, tt := range tests {
+		arg := tt.arg
+		profile := &Profile{
+			DefaultAction: ActErrno,
+			Architectures: []Arch{ArchX86},
+			Syscalls:      []*Syscall{{Names: []string{"personality"}, Action: ActAllow, Args: []*Arg{&arg}}},
+		}
+		program, err := Compile(profile, nil)
+		if err != nil {
+			t.Errorf("%s %#x: Compile error %v", tt.arg.Op, tt.arg.Value, err)
+			continue
+		}
+		data := &seccompData{arch: auditArchI386, nr: 136}
+		data.args[0] = tt.value
+		got, err := runFilter(program.Instructions, data)
+		if err != nil {
+			t.Errorf("%s %#x: run filter error %v", tt.arg.Op, tt.arg.Value, err)
+			continue
+		}
+		if (got == retAllow) != tt.want {
+			t.Errorf("%s %#x of %#x returns %#x, want match %v", tt.arg.Op, tt.arg.Value, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestCompileRuleOrder(t *testing.T) {
+	skipUnlessAmd64(t)
+	profile := &Profile{
+		DefaultAction: ActAllow,
+		Syscalls: []*Syscall{
+			{Names: []string{"socket"}, Action: ActErrno, ErrnoRet: uintPtr(1), Args: []*Arg{{Index: 0, Value: 40, Op: OpEqualTo}}},
+			{Names: []string{"socket"}, Action: ActErrno, ErrnoRet: uintPtr(2), Args: []*Arg{{Index: 0, Value: 10, Op: OpGreaterEqual}}},
+			{Names: []string{"socket"}, Action: ActLog},
+		},
+	}
+	tests := []struct {
+		domain uint64
+		want   uint32
+	}{
+		{40, retErrno | 1},
+		{41, retErrno | 2},
+		{10, retErrno | 2},
+		{2, retLog},
+	}
+	program, err := Compile(profile, nil)
+	if err != nil {
+		t.Fatalf("Compile error %v", err)
+	}
+	for _, tt := range tests {
+		data := &seccompData{arch: auditArchX86_64, nr: 41}
+		data.args[0] = tt.domain
+		got, err := runFilter(program.Instructions, data)
+		if err != nil {
+			t.Errorf("socket(%d): run filter error %v", tt.domain, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("socket(%d) returns %#x, want %#x", tt.domain, got, tt.want)
+		}
+	}
+}
+
+// TestCompileLongJumps needs jumps over more than 255 instructions, which
+// only BPF_JA reaches.
+func TestCompileLongJumps(t *testing.T) {
+	skipUnlessAmd64(t)
+	var names []string
+	for name := range syscallsX86_64 {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	names = names[:100]
+	profile := &Profile{DefaultAction: ActAllow, Architectures: []Arch{ArchX86}}
+	for i, name := range names {
+		profile.Syscalls = append(profile.Syscalls, &Syscall{
+			Names:    []string{name},
+			Action:   ActErrno,
+			ErrnoRet: uintPtr(uint(i + 1)),
+			Args:     []*Arg{{Index: 1, Value: 7, Op: OpNotEqual}},
+		})
+	}
+	program, err := Compile(profile, nil)
+	if err != nil {
+		t.Fatalf("Compile error %v", err)
+	}
+	if len(program.Instructions) <= 2*255 {
+		t.Fatalf("program of %d instructions is too short to need long jumps", len(program.Instructions))
+	}
+	for i, name := range names {
+		for _, arg := range []uint64{7, 8} {
+			data := &seccompData{arch: auditArchX86_64, nr: syscallsX86_64[name]}
+			data.args[1] = arg
+			got, err := runFilter(program.Instructions, data)
+			if err != nil {
+				t.Fatalf("%s: run filter error %v", name, err)
+			}
+			want := retErrno | uint32(i+1)
+			if arg == 7 {
+				want = retAllow
+			}
+			if got != want {
+				t.Errorf("%s(_, %d) returns %#x, want %#x", name, arg, got, want)
+			}
+		}
+	}
+	// the x86 section comes after every x86_64 rule.
+	got, err := runFilter(program.Instructions, &seccompData{arch: auditArchI386, nr: 20})
+	if err != nil || got != retAllow {
+		t.Errorf("x86 getpid returns %#x, %v, want %#x", got, err, retAllow)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	skipUnlessAmd64(t)
+	var tooLong []*Syscall
+	for name := range syscallsX86_64 {
+		tooLong = append(tooLong, &Syscall{Names: []string{name}, Action: ActErrno, Args: []*Arg{
+			{Index: 0, Value: 1, Op: OpEqualTo},
+			{Index: 1, Value: 1, Op: OpEqualTo},
+			{Index: 2, Value: 1, Op: OpEqualTo},
+		}})
+	}
+	tests := []struct {
+		name    string
+		profile *Profile
+	}{
+		{"unknown default action", &Profile{DefaultAction: "SCMP_ACT_NOTIFY"}},
+		{"unknown flag", &Profile{DefaultAction: ActAllow, Flags: []string{"SECCOMP_FILTER_FLAG_NEW_LISTENER"}}},
+		{"no names", &Profile{DefaultAction: ActAllow, Syscalls: []*Syscall{{Action: ActErrno}}}},
+		{"argument index", &Profile{DefaultAction: ActAllow, Syscalls: []*Syscall{{Names: []string{"read"}, Action: ActErrno, Args: []*Arg{{Index: 6, Op: OpEqualTo}}}}}},
+		{"unknown operator", &Profile{DefaultAction: ActAllow, Syscalls: []*Syscall{{Names: []string{"read"}, Action: ActErrno, Args: []*Arg{{Op: "SCMP_CMP_XX"}}}}}},
+		{"too long", &Profile{DefaultAction: ActAllow, Syscalls: tooLong}},
+	}
+	for _, tt := range tests {
+		if _, err := Compile(tt.profile, nil); err == nil {
+			t.Errorf("%s: Compile succeeds, want an error", tt.name)
+		}
+	}
+}
+
+func TestCompileDefaultProfile(t *testing.T) {
+	skipUnlessAmd64(t)
+	tests := []struct {
+		name string
+		caps []string
+		nr   uint32
+		args [6]uint64
+		want uint32
+	}{
+		{"read", nil, 0, [6]uint64{}, retAllow},
+		{"personality linux", nil, 135, [6]uint64{0}, retAllow},
+		{"personality other", nil, 135, [6]uint64{9}, retErrno | uint32(unix.EPERM)},
+		{"unshare", nil, 272, [6]uint64{}, retErrno | uint32(unix.EPERM)},
+		{"unshare with CAP_SYS_ADMIN", []string{"CAP_SYS_ADMIN"}, 272, [6]uint64{}, retAllow},
+	}
+	for _, tt := range tests {
+		program, err := Compile(DefaultProfile(), tt.caps)
+		if err != nil {
+			t.Fatalf("Compile error %v", err)
+		}
+		got, err := runFilter(program.Instructions, &seccompData{arch: auditArchX86_64, nr: tt.nr, args: tt.args})
+		if err != nil {
+			t.Errorf("%s: run filter error %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s returns %#x, want %#x", tt.name, got, tt.want)
+		}
+	}
+}
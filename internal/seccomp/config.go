@@ -0,0 +1,216 @@
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+type (
+	Action   string
+	Operator string
+	Arch     string
+)
+
+const (
+	ActKill        Action = "SCMP_ACT_KILL"
+	ActKillThread  Action = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess Action = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        Action = "SCMP_ACT_TRAP"
+	ActErrno       Action = "SCMP_ACT_ERRNO"
+	ActTrace       Action = "SCMP_ACT_TRACE"
+	ActLog         Action = "SCMP_ACT_LOG"
+	ActAllow       Action = "SCMP_ACT_ALLOW"
+)
+
+const (
+	OpNotEqual     Operator = "SCMP_CMP_NE"
+	OpLessThan     Operator = "SCMP_CMP_LT"
+	OpLessEqual    Operator = "SCMP_CMP_LE"
+	OpEqualTo      Operator = "SCMP_CMP_EQ"
+	OpGreaterEqual Operator = "SCMP_CMP_GE"
+	OpGreaterThan  Operator = "SCMP_CMP_GT"
+	OpMaskedEqual  Operator = "SCMP_CMP_MASKED_EQ"
+)
+
+const (
+	ArchX86     Arch = "SCMP_ARCH_X86"
+	ArchX86_64  Arch = "SCMP_ARCH_X86_64"
+	ArchX32     Arch = "SCMP_ARCH_X32"
+	ArchARM     Arch = "SCMP_ARCH_ARM"
+	ArchAARCH64 Arch = "SCMP_ARCH_AARCH64"
+)
+
+// Profile is a seccomp profile in the format of docker and of the
+// linux.seccomp section of the OCI runtime spec.
+type Profile struct {
+	DefaultAction   Action     `json:"defaultAction"`
+	DefaultErrnoRet *uint      `json:"defaultErrnoRet,omitempty"`
+	Architectures   []Arch     `json:"architectures,omitempty"`
+	ArchMap         []ArchMap  `json:"archMap,omitempty"`
+	Flags           []string   `json:"flags,omitempty"`
+	Syscalls        []*Syscall `json:"syscalls"`
+}
+
+// ArchMap lists the architectures a native architecture can also run,
+// e.g. x86 and x32 binaries on x86_64.
+type ArchMap struct {
+	Arch             Arch   `json:"architecture"`
+	SubArchitectures []Arch `json:"subArchitectures"`
+}
+
+// Syscall applies Action to the system calls Names whose arguments match
+// every Arg. Includes and Excludes restrict the rule to some capability
+// sets, architectures or kernels.
+type Syscall struct {
+	Names []string `json:"names,omitempty"`
+	// Name is the single system call of profiles before docker 1.13.
+	Name     string `json:"name,omitempty"`
+	Action   Action `json:"action"`
+	ErrnoRet *uint  `json:"errnoRet,omitempty"`
+	Args     []*Arg `json:"args,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+	Includes Filter `json:"includes,omitempty"`
+	Excludes Filter `json:"excludes,omitempty"`
+}
+
+// Arg compares argument Index of a system call to Value, MASKED_EQ masks
+// the argument with Value and compares it to ValueTwo.
+type Arg struct {
+	Index    uint     `json:"index"`
+	Value    uint64   `json:"value"`
+	ValueTwo uint64   `json:"valueTwo,omitempty"`
+	Op       Operator `json:"op"`
+}
+
+// Filter matches the capabilities of the container, the native
+// architecture and the kernel version.
+type Filter struct {
+	Caps      []string `json:"caps,omitempty"`
+	Arches    []string `json:"arches,omitempty"`
+	MinKernel string   `json:"minKernel,omitempty"`
+}
+
+// LoadProfile reads a profile file and checks that it compiles for the
+// native architecture.
+func LoadProfile(file string) (*Profile, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read seccomp profile %s error; %v", file, err)
+	}
+	profile := &Profile{}
+	if err := json.Unmarshal(content, profile); err != nil {
+		return nil, fmt.Errorf("unmarshal seccomp profile %s error; %v", file, err)
+	}
+	if _, err := Compile(profile, nil); err != nil {
+		return nil, fmt.Errorf("seccomp profile %s error; %v", file, err)
+	}
+	return profile, nil
+}
+
+// applies reports whether the rule applies to a container with caps on
+// this host, the way docker filters a profile before it hands it to runc.
+// Like docker the arches are the GOARCH names, e.g. amd64 and arm64.
+func (s *Syscall) applies(caps []string) bool {
+	arch := runtime.GOARCH
+	if len(s.Includes.Arches) > 0 && !containsString(s.Includes.Arches, arch) {
+		return false
+	}
+	for _, c := range s.Includes.Caps {
+		if !containsString(caps, c) {
+			return false
+		}
+	}
+	if s.Includes.MinKernel != "" && !kernelAtLeast(s.Includes.MinKernel) {
+		return false
+	}
+	if containsString(s.Excludes.Arches, arch) {
+		return false
+	}
+	for _, c := range s.Excludes.Caps {
+		if containsString(caps, c) {
+			return false
+		}
+	}
+	if s.Excludes.MinKernel != "" && kernelAtLeast(s.Excludes.MinKernel) {
+		return false
+	}
+	return true
+}
+
+func (s *Syscall) names() []string {
+	if s.Name != "" {
+		return append([]string{s.Name}, s.Names...)
+	}
+	return s.Names
+}
+
+// kernelAtLeast compares the running kernel to "major.minor".
+func kernelAtLeast(version string) bool {
+	var uts syscall.Utsname
+	if err := syscall.Uname(&uts); err != nil {
+		return false
+	}
+	var release []byte
+	for _, c := range uts.Release {
+		if c == 0 {
+			break
+		}
+		release = append(release, byte(c))
+	}
+	return compareVersion(string(release), version) >= 0
+}
+
+// compareVersion compares the leading numeric parts of two versions,
+// "5.15.0-generic" against "5.8" compares 5.15 to 5.8.
+func compareVersion(a, b string) int {
+	aParts, bParts := versionParts(a), versionParts(b)
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y int
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(version string) []int {
+	var parts []int
+	for _, field := range strings.SplitN(version, ".", 3) {
+		end := 0
+		for end < len(field) && field[end] >= '0' && field[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(field[:end])
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+		if end < len(field) {
+			break
+		}
+	}
+	return parts
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package seccomp
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"unsafe"
+)
+
+// operations and flags of seccomp(2).
+const (
+	seccompSetModeFilter       = 1
+	seccompFilterFlagTsync     = 1
+	seccompFilterFlagLog       = 2
+	seccompFilterFlagSpecAllow = 4
+)
+
+// Install loads the program into the kernel for every thread of the
+// process, the go runtime may run the execve of the user process on any of
+// them. The filter is kept across execve and inherited by children.
+//
+// Without no_new_privs the process needs CAP_SYS_ADMIN in its user
+// namespace, which root of the container has.
+func Install(program *Program) error {
+	if len(program.Instructions) == 0 {
+		return fmt.Errorf("empty seccomp filter")
+	}
+	prog := unix.SockFprog{
+		Len:    uint16(len(program.Instructions)),
+		Filter: &program.Instructions[0],
+	}
+	r1, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter,
+		seccompFilterFlagTsync|program.Flags, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("seccomp set mode filter error; %v", errno)
+	}
+	// with TSYNC a positive return value is a thread that could not be
+	// synchronized.
+	if r1 != 0 {
+		return fmt.Errorf("seccomp synchronize thread %d error", r1)
+	}
+	return nil
+}